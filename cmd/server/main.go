@@ -1,19 +1,43 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 
+	"github.com/SAMurai-16/sentinel-idp/internal/account"
+	"github.com/SAMurai-16/sentinel-idp/internal/admin"
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
 	"github.com/SAMurai-16/sentinel-idp/internal/auth"
+	"github.com/SAMurai-16/sentinel-idp/internal/dpop"
+	"github.com/SAMurai-16/sentinel-idp/internal/health"
+	"github.com/SAMurai-16/sentinel-idp/internal/janitor"
 	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+	"github.com/SAMurai-16/sentinel-idp/internal/mail"
+	"github.com/SAMurai-16/sentinel-idp/internal/metrics"
 	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
 	"github.com/SAMurai-16/sentinel-idp/internal/oauth"
 	"github.com/SAMurai-16/sentinel-idp/internal/oidc"
 	"github.com/SAMurai-16/sentinel-idp/internal/storage"
+	"github.com/SAMurai-16/sentinel-idp/internal/tlsconfig"
+	"github.com/SAMurai-16/sentinel-idp/internal/tracing"
 )
 
 
@@ -25,7 +49,16 @@ func main(){
 		log.Println("No .env file found, relying on environment variables")
 	}
 
-	
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Fatal("tracing setup failed: ", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Cancelled on SIGINT/SIGTERM; stops background jobs and the server.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == ""{
 		log.Fatal("DATABASE_URL not set")
@@ -38,6 +71,12 @@ func main(){
 
 	defer db.Close()
 
+	if err := storage.WaitForDB(ctx, db, 10); err != nil {
+		log.Fatal(err)
+	}
+
+	metrics.RegisterDB(db)
+
 
 
 
@@ -71,42 +110,207 @@ func main(){
 	}
 
 
-	authHandler := &auth.Handler{DB: db}
-	oauthHandler := &oauth.AuthorizeHandler{DB: db}
+	if err := configurePasswordHashing(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := configureSessions(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Sessions ended from auth (e.g. by a password reset, or a login
+	// over someone else's session) notify the clients signed in
+	// through them.
+	auth.SetSessionEnders(
+	func(db *sql.DB, sessionID string) error {
+		_, err := oidc.EndSession(db, sessionID)
+		return err
+	},
+	func(db *sql.DB, userID int) error {
+		_, err := oidc.EndUserSessions(db, userID)
+		return err
+	},
+	)
+
+	var mailer mail.Sender
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mailer = &mail.SMTPSender{
+			Addr:     addr,
+			From:     os.Getenv("SMTP_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	} else {
+		mailer = &mail.LogSender{Path: os.Getenv("MAIL_LOG_PATH")}
+	}
+
+	auditLog, err := newAuditLogger(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authHandler := &auth.Handler{
+	DB:      db,
+	Mailer:  mailer,
+	BaseURL: "http://localhost:8080",
+	Audit:   auditLog,
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	rpOrigins, err := webauthnOrigins(rpID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "Sentinel",
+		RPID:          rpID,
+		RPOrigins:     rpOrigins,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passkeyHandler := &auth.PasskeyHandler{DB: db, WebAuthn: wa, Audit: auditLog}
+
+	issuer := "http://localhost:8080"
+
+	// DPoP proofs name the URL the client called, which behind a proxy
+	// or on another port is not the issuer.
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if publicURL == "" {
+		publicURL = issuer
+	}
+
+	dpopVerifier := &dpop.Verifier{DB: db, BaseURL: publicURL}
+
+	var clientCAs *x509.CertPool
+	if caFile := os.Getenv("TLS_CLIENT_CA_FILE"); caFile != "" {
+		clientCAs, err = tlsconfig.LoadCertPool(caFile)
+		if err != nil {
+			log.Fatal("loading TLS_CLIENT_CA_FILE: ", err)
+		}
+	}
+	clientAuthenticator := &oauth.ClientAuthenticator{DB: db, Issuer: issuer, ClientCAs: clientCAs}
+
+	requirePAR := os.Getenv("REQUIRE_PAR") == "true"
+	oauthHandler := &oauth.AuthorizeHandler{
+		DB:         db,
+		Audit:      auditLog,
+		Issuer:     issuer,
+		Clients:    clientAuthenticator,
+		RequirePAR: requirePAR,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
 
 	tokenHandler := &oauth.TokenHandler{
-	DB:     db,
-	Signer: signer,
+	DB:      db,
+	Signer:  signer,
+	Clients: clientAuthenticator,
+	DPoP:    dpopVerifier,
+	Audit:   auditLog,
 	}
 
 	jwksHandler := &jwtutil.JWKSHandler{KeyManager: keyManager}
 
-	issuer := "http://localhost:8080"
 
-	go func() {
+	var workers sync.WaitGroup
+
+	workers.Go(func() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		previousKID := keyManager.ActiveKID()
+
 		if err := keyManager.ReloadFromDB(db); err != nil {
+			metrics.KeyReloads.WithLabelValues("failure").Inc()
 			log.Println("key reload failed:", err)
 		} else {
+			metrics.KeyReloads.WithLabelValues("success").Inc()
 			log.Println("signing keys reloaded")
 		}
+
+		if kid := keyManager.ActiveKID(); kid != previousKID {
+			auditLog.Record(nil, audit.Event{
+				Type:    audit.KeyActivated,
+				Details: map[string]interface{}{"kid": kid, "previous_kid": previousKID},
+			})
+		}
 	}
-	}()
+	})
+
+	janitorWorker := &janitor.Janitor{
+	DB:               db,
+	BatchSize:        500,
+	RevokedRetention: jwtutil.AccessTokenTTL,
+	}
+	workers.Go(func() { janitorWorker.Run(ctx, 5*time.Minute) })
+
+
+
+	notifier := &oidc.BackchannelNotifier{
+	DB:     db,
+	Signer: signer,
+	Client: &http.Client{Timeout: 5 * time.Second},
+	}
+
+	workers.Go(func() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := notifier.DeliverPending(); err != nil {
+			log.Println("backchannel logout delivery failed:", err)
+		}
+	}
+	})
 
 
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/login", authHandler.Login)
-	mux.HandleFunc("/login/", authHandler.Login)
+	mux.Handle("/login", metrics.Instrument("/login", http.HandlerFunc(authHandler.Login)))
+	mux.Handle("/login/", metrics.Instrument("/login", http.HandlerFunc(authHandler.Login)))
+	mux.HandleFunc("/register", authHandler.Register)
+	mux.HandleFunc("/verify-email", authHandler.VerifyEmail)
+	mux.HandleFunc("/forgot-password", authHandler.ForgotPassword)
+	mux.HandleFunc("/reset-password", authHandler.ResetPassword)
+
+	mux.HandleFunc("/webauthn/login/begin", passkeyHandler.BeginLogin)
+	mux.HandleFunc("/webauthn/login/finish", passkeyHandler.FinishLogin)
+	mux.Handle("/webauthn/register/begin",
+	middleware.RequireSession(db, http.HandlerFunc(passkeyHandler.BeginRegistration)),
+	)
+	mux.Handle("/webauthn/register/finish",
+	middleware.RequireSession(db, http.HandlerFunc(passkeyHandler.FinishRegistration)),
+	)
+	mux.Handle("/passkeys",
+	middleware.RequireSession(db, http.HandlerFunc(passkeyHandler.Page)),
+	)
 
 	protected := middleware.RequireSession(db, http.HandlerFunc(home))
 	mux.Handle("/", protected)
-	mux.Handle("/authorize",
-	middleware.RequireSession(db, http.HandlerFunc(oauthHandler.Authorize)),
-	)
+	// Authorize checks the session itself so prompt=none can answer
+	// login_required instead of redirecting to /login.
+	// The consent page posts back to /authorize.
+	mux.Handle("/authorize", metrics.Instrument("/authorize",
+		middleware.RequireCSRF(http.HandlerFunc(oauthHandler.Authorize)),
+	))
 	mux.Handle("/logout",
 	middleware.RequireCSRF(
 		http.HandlerFunc(oauthHandler.Logout),
@@ -115,26 +319,345 @@ func main(){
 
 
 
-	mux.HandleFunc("/token", tokenHandler.Token)
+	logoutHandler := &oidc.LogoutHandler{
+	DB:         db,
+	Issuer:     issuer,
+	KeyManager: keyManager,
+	}
+	mux.Handle("/end_session", metrics.Instrument("/end_session",
+	middleware.RequireCSRF(
+		http.HandlerFunc(logoutHandler.EndSession),
+	),
+	))
+
+	sessionsHandler := &account.SessionsHandler{DB: db}
+	mux.Handle("/account/sessions",
+	middleware.RequireSession(db, http.HandlerFunc(sessionsHandler.List)),
+	)
+	mux.Handle("/account/sessions/revoke",
+	middleware.RequireSession(db,
+		middleware.RequireCSRF(http.HandlerFunc(sessionsHandler.Revoke)),
+	),
+	)
+
+	adminHandler := &admin.Handler{DB: db, Audit: auditLog}
+	mux.Handle("GET /admin/audit",
+	middleware.RequireScope(db, keyManager, dpopVerifier, issuer, "admin:audit",
+		http.HandlerFunc(adminHandler.ListAuditEvents),
+	),
+	)
+	mux.Handle("POST /admin/users/{id}/sessions/revoke",
+	middleware.RequireScope(db, keyManager, dpopVerifier, issuer, "admin:users",
+		http.HandlerFunc(adminHandler.RevokeUserSessions),
+	),
+	)
+
+	var token http.Handler = http.HandlerFunc(tokenHandler.Token)
+	if os.Getenv("TOKEN_REQUIRE_CLIENT_CERT") == "true" {
+		token = middleware.RequireClientCert(token)
+	}
+	mux.Handle("/token", metrics.InstrumentToken(token))
 	mux.Handle("/jwks.json", jwksHandler)
 
+	introspectHandler := &oauth.IntrospectHandler{
+		DB:         db,
+		KeyManager: keyManager,
+		Issuer:     issuer,
+		Clients:    clientAuthenticator,
+	}
+	mux.Handle("/introspect", metrics.Instrument("/introspect", http.HandlerFunc(introspectHandler.Introspect)))
+
+	parHandler := &oauth.PARHandler{DB: db, Clients: clientAuthenticator, Issuer: issuer}
+	mux.Handle("/par", metrics.Instrument("/par", http.HandlerFunc(parHandler.Push)))
+
+	deviceHandler := &oauth.DeviceHandler{DB: db, Clients: clientAuthenticator, Issuer: issuer}
+	mux.Handle("/device_authorization",
+		metrics.Instrument("/device_authorization", http.HandlerFunc(deviceHandler.Authorize)),
+	)
+	mux.Handle("/device", metrics.Instrument("/device",
+		middleware.RequireSession(db,
+			middleware.RequireCSRF(http.HandlerFunc(deviceHandler.Page)),
+		),
+	))
+	mux.Handle("/userinfo", metrics.Instrument("/userinfo",
+		middleware.RequireAccessToken(db, keyManager, dpopVerifier, issuer, oidc.UserinfoHandler(db)),
+	))
+
 	mux.HandleFunc("/revoked", oauthHandler.IsRevoked)
 
 	mux.Handle(
 	"/.well-known/openid-configuration",
-	oidc.DiscoveryHandler(issuer),
+	oidc.DiscoveryHandler(issuer, requirePAR),
 	)
 
 
 
-	log.Println("Sentinel listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	mux.Handle("/metrics", metrics.Handler())
+
+	healthHandler := &health.Handler{
+		DB:         db,
+		KeyManager: keyManager,
+		// Keys reload every minute; allow a few failed attempts.
+		MaxKeyAge: 5 * time.Minute,
+	}
+	mux.HandleFunc("/healthz", healthHandler.Healthz)
+	mux.HandleFunc("/readyz", healthHandler.Readyz)
+
+	addr := os.Getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	tlsConfig, err := configureTLS(ctx, &workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		TLSConfig:         tlsConfig,
+		Handler:           tracing.Middleware(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		if tlsConfig != nil {
+			log.Println("Sentinel listening with TLS on", addr)
+			// Certificates come from TLSConfig.GetCertificate.
+			serveErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+
+		log.Println("Sentinel listening on", addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	// With TLS enabled, HTTP_REDIRECT_ADDR serves redirects to HTTPS.
+	var redirectServer *http.Server
+	if redirectAddr := os.Getenv("HTTP_REDIRECT_ADDR"); redirectAddr != "" && tlsConfig != nil {
+		_, httpsPort, _ := net.SplitHostPort(addr)
+		redirectServer = &http.Server{
+			Addr:              redirectAddr,
+			Handler:           tlsconfig.RedirectHandler(httpsPort),
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       5 * time.Second,
+			WriteTimeout:      5 * time.Second,
+		}
+
+		go func() {
+			log.Println("redirecting HTTP on", redirectAddr, "to HTTPS")
+			serveErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down")
+	healthHandler.Drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("graceful shutdown failed:", err)
+	}
+	workers.Wait()
 
 }
 
 
 
 
+// configurePasswordHashing applies PASSWORD_HASH (argon2id|bcrypt) and
+// its parameters, and loads PASSWORD_DENYLIST_PATH if set.
+func configurePasswordHashing() error {
+	switch os.Getenv("PASSWORD_HASH") {
+	case "", "argon2id":
+		h := auth.DefaultArgon2id()
+		if v, err := envUint("ARGON2_MEMORY_KIB", 32); err != nil {
+			return err
+		} else if v != 0 {
+			h.Memory = uint32(v)
+		}
+		if v, err := envUint("ARGON2_ITERATIONS", 32); err != nil {
+			return err
+		} else if v != 0 {
+			h.Iterations = uint32(v)
+		}
+		if v, err := envUint("ARGON2_PARALLELISM", 8); err != nil {
+			return err
+		} else if v != 0 {
+			h.Parallelism = uint8(v)
+		}
+		if err := h.Validate(); err != nil {
+			return err
+		}
+		auth.SetHasher(h)
+
+	case "bcrypt":
+		cost := bcrypt.DefaultCost
+		if v, err := envUint("BCRYPT_COST", 32); err != nil {
+			return err
+		} else if v != 0 {
+			cost = int(v)
+		}
+		auth.SetHasher(&auth.BcryptHasher{Cost: cost})
+
+	default:
+		return fmt.Errorf("unsupported PASSWORD_HASH %q", os.Getenv("PASSWORD_HASH"))
+	}
+
+	if path := os.Getenv("PASSWORD_DENYLIST_PATH"); path != "" {
+		return auth.LoadDenylist(path)
+	}
+	return nil
+}
+
+// newAuditLogger stores audit events in Postgres and additionally sends
+// them to the sinks listed in AUDIT_SINKS (comma separated: stdout,
+// file, webhook). The file sink writes to AUDIT_FILE and the webhook
+// sink posts to AUDIT_WEBHOOK_URL.
+func newAuditLogger(db *sql.DB) (*audit.Logger, error) {
+	logger := &audit.Logger{
+		Sinks: []audit.Sink{&audit.DBSink{DB: db}},
+	}
+
+	for _, name := range strings.Split(os.Getenv("AUDIT_SINKS"), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "stdout":
+			logger.Sinks = append(logger.Sinks, &audit.JSONSink{W: os.Stdout})
+		case "file":
+			sink, err := audit.NewFileSink(os.Getenv("AUDIT_FILE"))
+			if err != nil {
+				return nil, fmt.Errorf("audit file sink: %w", err)
+			}
+			logger.Sinks = append(logger.Sinks, sink)
+		case "webhook":
+			url := os.Getenv("AUDIT_WEBHOOK_URL")
+			if url == "" {
+				return nil, fmt.Errorf("AUDIT_WEBHOOK_URL not set")
+			}
+			logger.Sinks = append(logger.Sinks, &audit.WebhookSink{
+				URL:    url,
+				Client: &http.Client{Timeout: 5 * time.Second},
+			})
+		default:
+			return nil, fmt.Errorf("unknown audit sink %q", name)
+		}
+	}
+
+	return logger, nil
+}
+
+// webauthnOrigins returns the origins passkey ceremonies may come
+// from: WEBAUTHN_ORIGINS (comma-separated), else PUBLIC_BASE_URL, else
+// the local development server. Each must be on rpID or a subdomain of
+// it, or browsers would refuse every ceremony.
+func webauthnOrigins(rpID string) ([]string, error) {
+	var origins []string
+	switch {
+	case os.Getenv("WEBAUTHN_ORIGINS") != "":
+		for _, o := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+			origins = append(origins, strings.TrimSuffix(strings.TrimSpace(o), "/"))
+		}
+	case os.Getenv("PUBLIC_BASE_URL") != "":
+		origins = []string{strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")}
+	default:
+		origins = []string{"http://localhost:8080", "https://localhost:8080"}
+	}
+
+	for _, o := range origins {
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("invalid WebAuthn origin %q", o)
+		}
+		if host := u.Hostname(); host != rpID && !strings.HasSuffix(host, "."+rpID) {
+			return nil, fmt.Errorf("WebAuthn origin %q does not match WEBAUTHN_RP_ID %q", o, rpID)
+		}
+	}
+	return origins, nil
+}
+
+// configureSessions applies SESSION_IDLE_TIMEOUT and
+// SESSION_MAX_LIFETIME (Go durations, e.g. "30m", "168h").
+func configureSessions() error {
+	idle := auth.SessionIdleTimeout
+	max := auth.SessionMaxLifetime
+
+	if v := os.Getenv("SESSION_IDLE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid SESSION_IDLE_TIMEOUT: %w", err)
+		}
+		idle = d
+	}
+	if v := os.Getenv("SESSION_MAX_LIFETIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid SESSION_MAX_LIFETIME: %w", err)
+		}
+		max = d
+	}
+
+	if idle <= 0 || max < idle {
+		return fmt.Errorf("session idle timeout must be positive and not exceed the max lifetime")
+	}
+
+	auth.SetSessionLifetimes(idle, max)
+	return nil
+}
+
+// configureTLS loads TLS_CERT_FILE and TLS_KEY_FILE, reloading them
+// when they change. Client certificates are requested when
+// TLS_CLIENT_CA_FILE or TLS_REQUEST_CLIENT_CERT=true is set. It returns
+// nil when TLS is not configured.
+func configureTLS(ctx context.Context, workers *sync.WaitGroup) (*tls.Config, error) {
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	reloader, err := tlsconfig.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	workers.Go(func() { reloader.Watch(ctx, 30*time.Second) })
+
+	requestClientCert := os.Getenv("TLS_CLIENT_CA_FILE") != "" ||
+		os.Getenv("TLS_REQUEST_CLIENT_CERT") == "true"
+	return tlsconfig.Server(reloader, requestClientCert), nil
+}
+
+// envUint parses the unsigned integer in name, which must fit in bitSize
+// bits. An unset variable yields 0.
+func envUint(name string, bitSize int) (uint64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return n, nil
+}
+
 func home(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Sentinel running"))
 }
\ No newline at end of file
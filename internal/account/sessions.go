@@ -0,0 +1,117 @@
+package account
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/auth"
+	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
+	"github.com/SAMurai-16/sentinel-idp/internal/oidc"
+)
+
+// SessionsHandler lets users review and terminate their own sessions.
+type SessionsHandler struct {
+	DB *sql.DB
+}
+
+type sessionView struct {
+	SID         string
+	UserAgent   string
+	IP          string
+	AuthMethods string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ExpiresAt   time.Time
+	Current     bool
+}
+
+// List renders the signed-in user's active sessions.
+func (h *SessionsHandler) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.SessionUserID(h.DB, r)
+	if err != nil {
+		auth.RedirectToLogin(h.DB, w, r)
+		return
+	}
+
+	cookie, _ := r.Cookie("sentinel_session")
+
+	rows, err := h.DB.Query(`
+		SELECT id, sid, user_agent, ip, auth_methods, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id=$1 AND expires_at > now()
+		ORDER BY last_seen_at DESC
+	`, userID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var sessions []sessionView
+	for rows.Next() {
+		var id string
+		var s sessionView
+		err := rows.Scan(&id, &s.SID, &s.UserAgent, &s.IP, &s.AuthMethods, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		s.Current = id == cookie.Value
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("web/templates/sessions.html")
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, map[string]interface{}{
+		"Sessions":  sessions,
+		"CSRFToken": middleware.IssueCSRFToken(w),
+	})
+}
+
+// Revoke terminates one of the signed-in user's sessions by sid
+// (CSRF protected).
+func (h *SessionsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := auth.SessionUserID(h.DB, r)
+	if err != nil {
+		auth.RedirectToLogin(h.DB, w, r)
+		return
+	}
+
+	sid := strings.TrimSpace(r.FormValue("sid"))
+
+	var owner int
+	err = h.DB.QueryRow("SELECT user_id FROM sessions WHERE sid=$1", sid).Scan(&owner)
+	if err != nil || owner != userID {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := oidc.EndSessionBySID(h.DB, sid); err != nil {
+		log.Println("session revoke failed:", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
+)
+
+const maxAuditLimit = 500
+
+// ListAuditEvents returns audit events, newest first. Optional filters:
+// type, user_id, client_id, since and until (RFC 3339), limit, and
+// before_id for paging.
+func (h *Handler) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if v := q.Get("type"); v != "" {
+		add("type = $%d", v)
+	}
+	if v := q.Get("client_id"); v != "" {
+		add("client_id = $%d", v)
+	}
+	if v := q.Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid user_id", http.StatusBadRequest)
+			return
+		}
+		add("user_id = $%d", id)
+	}
+	for param, cond := range map[string]string{"since": "occurred_at >= $%d", "until": "occurred_at < $%d"} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid "+param, http.StatusBadRequest)
+				return
+			}
+			add(cond, t.UTC())
+		}
+	}
+	if v := q.Get("before_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid before_id", http.StatusBadRequest)
+			return
+		}
+		add("id < $%d", id)
+	}
+
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	query := `SELECT id, occurred_at, type, user_id, client_id, ip, user_agent, details FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type row struct {
+		ID int64 `json:"id"`
+		audit.Event
+	}
+
+	events := []row{}
+	for rows.Next() {
+		var (
+			e                       row
+			userID                  *int
+			clientID, ip, userAgent *string
+			details                 []byte
+		)
+		if err := rows.Scan(&e.ID, &e.Time, &e.Type, &userID, &clientID, &ip, &userAgent, &details); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		e.UserID = userID
+		if clientID != nil {
+			e.ClientID = *clientID
+		}
+		if ip != nil {
+			e.IP = *ip
+		}
+		if userAgent != nil {
+			e.UserAgent = *userAgent
+		}
+		if len(details) > 0 {
+			json.Unmarshal(details, &e.Details)
+		}
+
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+	})
+}
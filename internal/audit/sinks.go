@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// DBSink stores events in the audit_events table.
+type DBSink struct {
+	DB *sql.DB
+}
+
+func (s *DBSink) Write(e Event) error {
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec(
+		`INSERT INTO audit_events
+		 (occurred_at, type, user_id, client_id, ip, user_agent, details)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		e.Time, e.Type, e.UserID, nullIfEmpty(e.ClientID), e.IP, e.UserAgent, details,
+	)
+	return err
+}
+
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// JSONSink writes one JSON object per line, e.g. to os.Stdout.
+type JSONSink struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func (s *JSONSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.W.Write(append(b, '\n'))
+	return err
+}
+
+// NewFileSink appends JSON lines to the file at path.
+func NewFileSink(path string) (*JSONSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONSink{W: f}, nil
+}
+
+// WebhookSink POSTs each event as JSON to URL. Delivery happens in the
+// background; events are dropped (and logged) if the queue is full.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+
+	queue chan Event
+	once  sync.Once
+}
+
+const webhookQueueSize = 1024
+
+func (s *WebhookSink) Write(e Event) error {
+	s.once.Do(func() {
+		s.queue = make(chan Event, webhookQueueSize)
+		go s.run()
+	})
+
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue full, dropping event")
+	}
+}
+
+func (s *WebhookSink) run() {
+	for e := range s.queue {
+		if err := s.post(e); err != nil {
+			log.Printf("audit webhook failed for %s: %v", e.Type, err)
+		}
+	}
+}
+
+func (s *WebhookSink) post(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
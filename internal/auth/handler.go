@@ -2,16 +2,31 @@ package auth
 
 import (
 	"database/sql"
+	"html/template"
+	"log"
 	"net/http"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
+	"github.com/SAMurai-16/sentinel-idp/internal/mail"
 )
 
 type Handler struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Mailer  mail.Sender
+	BaseURL string
+	Audit   *audit.Logger
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		http.ServeFile(w, r, "web/templates/login.html")
+		tmpl, err := template.ParseFiles("web/templates/login.html")
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, map[string]string{
+			"LoginHint": r.URL.Query().Get("login_hint"),
+		})
 		return
 	}
 
@@ -21,36 +36,64 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var userID int
 	var hash string
 
-	err := h.DB.QueryRow(
+	err := h.DB.QueryRowContext(r.Context(),
 		"SELECT id, password_hash FROM users WHERE username=$1",
 		username,
 	).Scan(&userID, &hash)
 
-	if err != nil || !CheckPassword(hash, password) {
+	if err != nil {
+		h.Audit.Record(r, audit.Event{
+			Type:    audit.LoginFailed,
+			Details: map[string]interface{}{"username": username, "reason": "unknown user", "method": "pwd"},
+		})
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	ok, rehash := CheckPassword(hash, password)
+	if !ok {
+		h.Audit.Record(r, audit.Event{
+			Type:    audit.LoginFailed,
+			UserID:  audit.User(userID),
+			Details: map[string]interface{}{"reason": "wrong password", "method": "pwd"},
+		})
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	sessionID := NewSessionID()
-	expires := SessionExpiry()
+	if rehash {
+		h.upgradeHash(userID, hash, password)
+	}
 
-	_, err = h.DB.Exec(
-		"INSERT INTO sessions (id, user_id, expires_at) VALUES ($1,$2,$3)",
-		sessionID, userID, expires,
-	)
-	if err != nil {
+	if err := StartSession(h.DB, w, r, userID, []string{"pwd"}); err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "sentinel_session",
-		Value:    sessionID,
-		Expires:  expires,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
+	h.Audit.Record(r, audit.Event{
+		Type:    audit.LoginSucceeded,
+		UserID:  audit.User(userID),
+		Details: map[string]interface{}{"method": "pwd", "rehashed": rehash},
 	})
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, ConsumeReturnTo(h.DB, w, r), http.StatusFound)
+}
+
+// upgradeHash replaces an outdated password hash after a successful
+// login. Failures are logged and do not block the login.
+func (h *Handler) upgradeHash(userID int, oldHash, password string) {
+	newHash, err := HashPassword(password)
+	if err != nil {
+		log.Println("password rehash failed:", err)
+		return
+	}
+
+	// Only replace the hash we verified, in case it changed meanwhile.
+	_, err = h.DB.Exec(
+		"UPDATE users SET password_hash=$1, updated_at=now() WHERE id=$2 AND password_hash=$3",
+		newHash, userID, oldHash,
+	)
+	if err != nil {
+		log.Println("password rehash failed:", err)
+	}
 }
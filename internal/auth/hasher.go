@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher produces and verifies encoded password hashes. Encodings are
+// self-describing (PHC strings, or modular crypt for bcrypt) so hashes
+// from an older configuration keep verifying after a change.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) bool
+
+	// Matches reports whether encoded uses this hasher's algorithm.
+	Matches(encoded string) bool
+
+	// NeedsRehash reports whether encoded was produced with weaker or
+	// different parameters than the hasher is configured with.
+	NeedsRehash(encoded string) bool
+}
+
+// BcryptHasher hashes with bcrypt at Cost.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(b), err
+}
+
+func (h *BcryptHasher) Verify(encoded, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (h *BcryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes with Argon2id and encodes the result as
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<hash>.
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id returns the parameters used for new hashes unless
+// configured otherwise.
+func DefaultArgon2id() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// maxArgon2Memory caps the memory cost (in KiB) accepted from a stored
+// hash or the configuration, so a tampered hash cannot make a login
+// allocate without bound.
+const maxArgon2Memory = 4 * 1024 * 1024
+
+// Validate reports whether the parameters can be used for hashing.
+func (h *Argon2idHasher) Validate() error {
+	switch {
+	case h.Iterations < 1:
+		return errors.New("argon2id: iterations must be at least 1")
+	case h.Parallelism < 1:
+		return errors.New("argon2id: parallelism must be at least 1")
+	case h.Memory < 8*uint32(h.Parallelism):
+		return errors.New("argon2id: memory must be at least 8 KiB per lane")
+	case h.Memory > maxArgon2Memory:
+		return fmt.Errorf("argon2id: memory must be at most %d KiB", maxArgon2Memory)
+	case h.SaltLength < 1 || h.KeyLength < 1:
+		return errors.New("argon2id: salt and key lengths must be at least 1")
+	}
+	return nil
+}
+
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+var errInvalidPHC = errors.New("invalid argon2id hash")
+
+func parseArgon2id(encoded string) (*argon2Hash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errInvalidPHC
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errInvalidPHC
+	}
+
+	var p argon2Hash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return nil, errInvalidPHC
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errInvalidPHC
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, errInvalidPHC
+	}
+
+	// argon2.IDKey panics on t=0 or p=0, and an empty key would compare
+	// equal to any password's. The memory cost is also capped, since it
+	// is allocated on every verification.
+	if p.iterations < 1 || p.parallelism < 1 || p.memory < 8*uint32(p.parallelism) ||
+		p.memory > maxArgon2Memory || len(p.salt) == 0 || len(p.key) == 0 {
+		return nil, errInvalidPHC
+	}
+
+	return &p, nil
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded, password string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1
+}
+
+func (h *Argon2idHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return p.memory != h.Memory ||
+		p.iterations != h.Iterations ||
+		p.parallelism != h.Parallelism ||
+		uint32(len(p.salt)) != h.SaltLength ||
+		uint32(len(p.key)) != h.KeyLength
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+// testArgon2id is cheap enough to run in tests.
+func testArgon2id() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64,
+		Iterations:  1,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func TestParseArgon2id(t *testing.T) {
+	const salt = "c29tZXNhbHQ"
+	const key = "iWh06vD8Fy27wf9npn6FXWiCX4K6pW6Ue1Bnzz07Z8A"
+
+	tests := []struct {
+		name    string
+		encoded string
+		ok      bool
+	}{
+		{"valid", "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$" + key, true},
+		{"minimum memory", "$argon2id$v=19$m=16,t=1,p=2$" + salt + "$" + key, true},
+		{"wrong algorithm", "$argon2i$v=19$m=65536,t=3,p=2$" + salt + "$" + key, false},
+		{"wrong version", "$argon2id$v=16$m=65536,t=3,p=2$" + salt + "$" + key, false},
+		{"missing part", "$argon2id$v=19$m=65536,t=3,p=2$" + salt, false},
+		{"zero iterations", "$argon2id$v=19$m=65536,t=0,p=2$" + salt + "$" + key, false},
+		{"zero parallelism", "$argon2id$v=19$m=65536,t=3,p=0$" + salt + "$" + key, false},
+		{"parallelism overflow", "$argon2id$v=19$m=65536,t=3,p=256$" + salt + "$" + key, false},
+		{"memory below 8 per lane", "$argon2id$v=19$m=15,t=1,p=2$" + salt + "$" + key, false},
+		{"memory above cap", "$argon2id$v=19$m=4194305,t=1,p=1$" + salt + "$" + key, false},
+		{"empty salt", "$argon2id$v=19$m=65536,t=3,p=2$$" + key, false},
+		{"empty key", "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$", false},
+		{"bad base64", "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$!!!", false},
+		{"bcrypt", "$2a$10$abcdefghijklmnopqrstuu", false},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseArgon2id(tt.encoded); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := testArgon2id()
+
+	encoded, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=2$") {
+		t.Errorf("encoded = %s", encoded)
+	}
+
+	if !h.Matches(encoded) {
+		t.Error("Matches = false")
+	}
+	if !h.Verify(encoded, "correct horse battery staple") {
+		t.Error("Verify rejected the password")
+	}
+	if h.Verify(encoded, "correct horse battery stapler") {
+		t.Error("Verify accepted a wrong password")
+	}
+	if h.NeedsRehash(encoded) {
+		t.Error("NeedsRehash = true for current parameters")
+	}
+
+	stronger := testArgon2id()
+	stronger.Iterations = 2
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("NeedsRehash = false after raising iterations")
+	}
+	if !stronger.Verify(encoded, "correct horse battery staple") {
+		t.Error("Verify rejected a hash with older parameters")
+	}
+}
+
+func TestArgon2idValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(h *Argon2idHasher)
+		ok     bool
+	}{
+		{"defaults", func(h *Argon2idHasher) { *h = *DefaultArgon2id() }, true},
+		{"test parameters", func(h *Argon2idHasher) {}, true},
+		{"zero iterations", func(h *Argon2idHasher) { h.Iterations = 0 }, false},
+		{"zero parallelism", func(h *Argon2idHasher) { h.Parallelism = 0 }, false},
+		{"memory below 8 per lane", func(h *Argon2idHasher) { h.Memory = 15 }, false},
+		{"memory above cap", func(h *Argon2idHasher) { h.Memory = maxArgon2Memory + 1 }, false},
+		{"no salt", func(h *Argon2idHasher) { h.SaltLength = 0 }, false},
+	}
+
+	for _, tt := range tests {
+		h := testArgon2id()
+		tt.modify(h)
+		if err := h.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
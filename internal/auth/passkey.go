@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
+)
+
+const ceremonyCookie = "sentinel_webauthn"
+
+// PasskeyHandler implements the WebAuthn registration and assertion
+// ceremonies. Successful assertions start a regular sentinel_session.
+type PasskeyHandler struct {
+	DB       *sql.DB
+	WebAuthn *webauthn.WebAuthn
+	Audit    *audit.Logger
+}
+
+// passkeyUser adapts a users row to webauthn.User. The user handle is
+// the decimal user id.
+type passkeyUser struct {
+	id          int
+	username    string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return []byte(strconv.Itoa(u.id)) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func (h *PasskeyHandler) loadUser(userID int) (*passkeyUser, error) {
+	u := &passkeyUser{id: userID}
+
+	err := h.DB.QueryRow(
+		"SELECT username FROM users WHERE id=$1",
+		userID,
+	).Scan(&u.username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.DB.Query(
+		"SELECT credential FROM webauthn_credentials WHERE user_id=$1",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+
+		var cred webauthn.Credential
+		if err := json.Unmarshal(raw, &cred); err != nil {
+			return nil, err
+		}
+		u.credentials = append(u.credentials, cred)
+	}
+
+	return u, rows.Err()
+}
+
+// saveCeremony persists the WebAuthn session data between the begin
+// and finish requests and points a short-lived cookie at it.
+func (h *PasskeyHandler) saveCeremony(w http.ResponseWriter, userID *int, data *webauthn.SessionData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.NewString()
+	expires := time.Now().Add(5 * time.Minute)
+
+	_, err = h.DB.Exec(
+		"INSERT INTO webauthn_sessions (id, user_id, data, expires_at) VALUES ($1,$2,$3,$4)",
+		id, userID, raw, expires,
+	)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ceremonyCookie,
+		Value:    id,
+		Path:     "/webauthn/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return nil
+}
+
+// consumeCeremony loads and deletes the session data created by
+// saveCeremony, so each challenge can only be answered once.
+func (h *PasskeyHandler) consumeCeremony(w http.ResponseWriter, r *http.Request) (*webauthn.SessionData, error) {
+	cookie, err := r.Cookie(ceremonyCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   ceremonyCookie,
+		Value:  "",
+		Path:   "/webauthn/",
+		MaxAge: -1,
+	})
+
+	var raw []byte
+	err = h.DB.QueryRow(
+		`DELETE FROM webauthn_sessions
+		 WHERE id=$1 AND expires_at > now()
+		 RETURNING data`,
+		cookie.Value,
+	).Scan(&raw)
+	if err != nil {
+		return nil, errors.New("webauthn ceremony expired")
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Page serves the passkey management page.
+func (h *PasskeyHandler) Page(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "web/templates/passkeys.html")
+}
+
+// BeginRegistration returns credential creation options for the
+// logged-in user.
+func (h *PasskeyHandler) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := SessionUserID(h.DB, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	user, err := h.loadUser(userID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	var exclude []protocol.CredentialDescriptor
+	for _, c := range user.credentials {
+		exclude = append(exclude, c.Descriptor())
+	}
+
+	options, data, err := h.WebAuthn.BeginRegistration(
+		user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclude),
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.saveCeremony(w, &userID, data); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, options)
+}
+
+// FinishRegistration verifies the attestation and stores the new
+// credential for the logged-in user.
+func (h *PasskeyHandler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := SessionUserID(h.DB, r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	data, err := h.consumeCeremony(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.loadUser(userID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	cred, err := h.WebAuthn.FinishRegistration(user, *data, r)
+	if err != nil {
+		http.Error(w, "passkey registration failed", http.StatusBadRequest)
+		return
+	}
+
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.DB.Exec(
+		"INSERT INTO webauthn_credentials (id, user_id, credential) VALUES ($1,$2,$3)",
+		cred.ID, userID, raw,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	// A new credential changes what the session can do.
+	if err := RotateSession(h.DB, w, r); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// BeginLogin returns assertion options for a discoverable (usernameless)
+// passkey login.
+func (h *PasskeyHandler) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	options, data, err := h.WebAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.saveCeremony(w, nil, data); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, options)
+}
+
+// FinishLogin verifies the assertion and starts a session whose amr
+// records hardware-key authentication.
+func (h *PasskeyHandler) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := h.consumeCeremony(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user *passkeyUser
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+
+		user, err = h.loadUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	cred, err := h.WebAuthn.FinishDiscoverableLogin(lookup, *data, r)
+	if err != nil || user == nil || cred.Authenticator.CloneWarning {
+		e := audit.Event{
+			Type:    audit.LoginFailed,
+			Details: map[string]interface{}{"method": "hwk"},
+		}
+		if user != nil {
+			e.UserID = audit.User(user.id)
+		}
+		if cred != nil && cred.Authenticator.CloneWarning {
+			e.Details["reason"] = "authenticator clone suspected"
+		}
+		h.Audit.Record(r, e)
+
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	raw, err := json.Marshal(cred)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Persist the new sign counter so cloned authenticators are detected.
+	_, err = h.DB.Exec(
+		`UPDATE webauthn_credentials
+		 SET credential=$1, last_used_at=now()
+		 WHERE id=$2 AND user_id=$3`,
+		raw, cred.ID, user.id,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	methods := []string{"hwk"}
+	if cred.Flags.UserVerified {
+		methods = append(methods, "user")
+	}
+
+	if err := StartSession(h.DB, w, r, user.id, methods); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Record(r, audit.Event{
+		Type:    audit.LoginSucceeded,
+		UserID:  audit.User(user.id),
+		Details: map[string]interface{}{"method": "hwk"},
+	})
+
+	writeJSON(w, map[string]string{"redirect": ConsumeReturnTo(h.DB, w, r)})
+}
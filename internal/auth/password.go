@@ -1,12 +1,126 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 12
+	// bcrypt ignores everything past 72 bytes.
+	maxPasswordLength = 72
+)
+
+// currentHasher produces new hashes. legacyHashers can still verify
+// hashes written under an earlier configuration.
+var (
+	currentHasher Hasher = DefaultArgon2id()
+	legacyHashers        = []Hasher{
+		&BcryptHasher{Cost: bcrypt.DefaultCost},
+		DefaultArgon2id(),
+	}
+)
+
+// SetHasher changes the algorithm and parameters used for new hashes.
+// Existing hashes in other formats keep verifying and are upgraded on
+// the next successful login.
+func SetHasher(h Hasher) {
+	currentHasher = h
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+	return currentHasher.Hash(password)
+}
+
+// CheckPassword verifies password against an encoded hash. rehash is
+// true when the password matched but the hash should be replaced with
+// one from HashPassword.
+func CheckPassword(hash, password string) (ok bool, rehash bool) {
+	if currentHasher.Matches(hash) {
+		if !currentHasher.Verify(hash, password) {
+			return false, false
+		}
+		return true, currentHasher.NeedsRehash(hash)
+	}
+
+	for _, h := range legacyHashers {
+		if h.Matches(hash) {
+			return h.Verify(hash, password), true
+		}
+	}
+
+	return false, false
+}
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+var (
+	denylistMu sync.RWMutex
+	denylist   = parseDenylist(commonPasswords)
+)
+
+func parseDenylist(s string) map[string]struct{} {
+	m := make(map[string]struct{})
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			m[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return m
+}
+
+// LoadDenylist adds the passwords in path (one per line) to the
+// built-in list of common and breached passwords.
+func LoadDenylist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	denylistMu.Lock()
+	defer denylistMu.Unlock()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			denylist[strings.ToLower(line)] = struct{}{}
+		}
+	}
+
+	return scanner.Err()
+}
+
+func isDenylisted(password string) bool {
+	denylistMu.RLock()
+	defer denylistMu.RUnlock()
+
+	_, found := denylist[strings.ToLower(password)]
+	return found
 }
 
-func CheckPassword(hash, password string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+// ValidatePassword enforces the password policy for newly chosen
+// passwords.
+func ValidatePassword(password, username string) error {
+	if len(password) < minPasswordLength {
+		return errors.New("password must be at least 12 characters")
+	}
+	if len(password) > maxPasswordLength {
+		return errors.New("password must be at most 72 bytes")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+	if isDenylisted(password) {
+		return errors.New("password is too common or has appeared in a data breach")
+	}
+	return nil
 }
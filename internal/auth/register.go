@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const verificationTTL = 24 * time.Hour
+
+// Register creates an unverified account and emails a verification link.
+// An address that is already registered gets the same response, so the
+// form cannot be used to find out who has an account.
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		http.ServeFile(w, r, "web/templates/register.html")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	email := strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+
+	if username == "" {
+		http.Error(w, "username required", http.StatusBadRequest)
+		return
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		http.Error(w, "invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	if password != r.FormValue("password_confirm") {
+		http.Error(w, "passwords do not match", http.StatusBadRequest)
+		return
+	}
+
+	if err := ValidatePassword(password, username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	const done = "Check your email to verify your account"
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(
+		`INSERT INTO users (username, email, password_hash, email_verified)
+		 VALUES ($1,$2,$3,false)
+		 RETURNING id`,
+		username, strings.ToLower(email), hash,
+	).Scan(&userID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		if pqErr.Constraint != "users_email_key" {
+			http.Error(w, "username already taken", http.StatusConflict)
+			return
+		}
+
+		err = h.Mailer.Send(
+			email,
+			"Sentinel account registration",
+			"Someone tried to create a Sentinel account with this email address, which already has one. If that was you, sign in or reset your password instead. Otherwise you can ignore this email.",
+		)
+		if err != nil {
+			log.Println("registration notice:", err)
+		}
+		w.Write([]byte(done))
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	raw, tokenHash := newToken()
+
+	_, err = tx.Exec(
+		`INSERT INTO email_verification_tokens (token_hash, user_id, expires_at)
+		 VALUES ($1,$2,$3)`,
+		tokenHash, userID, time.Now().Add(verificationTTL),
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The account is only committed once the link is on its way, so a
+	// failed send leaves the address free to register again.
+	link := h.BaseURL + "/verify-email?token=" + url.QueryEscape(raw)
+	err = h.Mailer.Send(
+		email,
+		"Verify your Sentinel account",
+		"Open the link below to verify your email address:\n\n"+link+"\n\nThe link expires in 24 hours.",
+	)
+	if err != nil {
+		http.Error(w, "failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte(done))
+}
+
+// VerifyEmail consumes a verification token and marks the account as
+// verified.
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("token")
+	if raw == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(
+		`DELETE FROM email_verification_tokens
+		 WHERE token_hash=$1 AND expires_at > now()
+		 RETURNING user_id`,
+		hashToken(raw),
+	).Scan(&userID)
+	if err != nil {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET email_verified=true, updated_at=now() WHERE id=$1",
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	resetTokenTTL = 30 * time.Minute
+
+	// At most resetRequestLimit reset emails are sent per account
+	// within resetRequestWindow.
+	resetRequestLimit  = 3
+	resetRequestWindow = time.Hour
+)
+
+// ForgotPassword emails a single-use reset link. The response is the
+// same whether or not the address belongs to an account.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		http.ServeFile(w, r, "web/templates/forgot_password.html")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	const done = "If the address is registered, a reset link has been sent"
+
+	email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+	if email == "" {
+		http.Error(w, "email required", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	err := h.DB.QueryRow(
+		"SELECT id FROM users WHERE email=$1",
+		email,
+	).Scan(&userID)
+	if err != nil {
+		w.Write([]byte(done))
+		return
+	}
+
+	var recent int
+	err = h.DB.QueryRow(
+		`SELECT count(*) FROM password_reset_tokens
+		 WHERE user_id=$1 AND created_at > $2`,
+		userID, time.Now().Add(-resetRequestWindow),
+	).Scan(&recent)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	if recent >= resetRequestLimit {
+		log.Printf("password reset rate limited user_id=%d", userID)
+		w.Write([]byte(done))
+		return
+	}
+
+	raw, tokenHash := newToken()
+
+	_, err = h.DB.Exec(
+		`INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+		 VALUES ($1,$2,$3)`,
+		tokenHash, userID, time.Now().Add(resetTokenTTL),
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	link := h.BaseURL + "/reset-password?token=" + url.QueryEscape(raw)
+	err = h.Mailer.Send(
+		email,
+		"Reset your Sentinel password",
+		"Open the link below to choose a new password:\n\n"+link+"\n\nThe link expires in 30 minutes. If you did not request a reset, ignore this email.",
+	)
+	if err != nil {
+		http.Error(w, "failed to send reset email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte(done))
+}
+
+// ResetPassword consumes a reset token, sets the new password and
+// signs the user out everywhere.
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		http.ServeFile(w, r, "web/templates/reset_password.html")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The form posts back to its own URL, so the token arrives in the
+	// query string.
+	raw := r.FormValue("token")
+	password := r.FormValue("password")
+
+	if raw == "" {
+		http.Error(w, "missing token", http.StatusBadRequest)
+		return
+	}
+
+	if password != r.FormValue("password_confirm") {
+		http.Error(w, "passwords do not match", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var username string
+	err = tx.QueryRow(
+		`SELECT t.user_id, u.username
+		 FROM password_reset_tokens t
+		 JOIN users u ON u.id = t.user_id
+		 WHERE t.token_hash=$1 AND t.used_at IS NULL AND t.expires_at > now()
+		 FOR UPDATE OF t`,
+		hashToken(raw),
+	).Scan(&userID, &username)
+	if err != nil {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if err := ValidatePassword(password, username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Burn this token and any other outstanding ones for the account.
+	_, err = tx.Exec(
+		"UPDATE password_reset_tokens SET used_at=now() WHERE user_id=$1 AND used_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET password_hash=$1, updated_at=now() WHERE id=$2",
+		hash, userID,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(
+		"UPDATE refresh_tokens SET revoked=true WHERE user_id=$1 AND revoked=false",
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Sign out everywhere the old password was used.
+	if err := endUserSessions(h.DB, userID); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
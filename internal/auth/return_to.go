@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	returnCookie = "sentinel_return"
+	returnTTL    = 10 * time.Minute
+)
+
+// safeReturnPath reports whether target is a local path that is safe to
+// redirect to after login. Absolute and scheme-relative URLs are
+// rejected to prevent open redirects.
+func safeReturnPath(target string) bool {
+	if !strings.HasPrefix(target, "/") ||
+		strings.HasPrefix(target, "//") ||
+		strings.ContainsAny(target, "\\\r\n") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	return err == nil && u.Scheme == "" && u.Host == "" && u.User == nil
+}
+
+// RedirectToLogin sends the user to /login. For page navigations the
+// original URL (e.g. a pending /authorize request) is stored server-side
+// so login can resume it. Subresource requests such as /favicon.ico are
+// skipped so they don't overwrite the pending request.
+func RedirectToLogin(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	navigation := r.Method == http.MethodGet &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+
+	if navigation {
+		SaveReturnTo(db, w, r.URL.RequestURI())
+	}
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// SaveReturnTo records target as the page to resume after the next
+// successful login. Unsafe targets are ignored.
+func SaveReturnTo(db *sql.DB, w http.ResponseWriter, target string) {
+	if !safeReturnPath(target) {
+		return
+	}
+
+	id := uuid.NewString()
+	expires := time.Now().Add(returnTTL)
+
+	_, err := db.Exec(
+		"INSERT INTO pending_logins (id, return_to, expires_at) VALUES ($1,$2,$3)",
+		id, target, expires,
+	)
+	if err != nil {
+		log.Println("failed to store pending login:", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     returnCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ConsumeReturnTo returns where to send the user after a successful
+// login and forgets the stored request. It falls back to "/".
+func ConsumeReturnTo(db *sql.DB, w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(returnCookie)
+	if err != nil {
+		return "/"
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   returnCookie,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	var target string
+	err = db.QueryRow(
+		`DELETE FROM pending_logins
+		 WHERE id=$1 AND expires_at > now()
+		 RETURNING return_to`,
+		cookie.Value,
+	).Scan(&target)
+	if err != nil || !safeReturnPath(target) {
+		return "/"
+	}
+
+	return target
+}
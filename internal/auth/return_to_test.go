@@ -0,0 +1,27 @@
+package auth
+
+import "testing"
+
+func TestSafeReturnPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/", true},
+		{"/authorize?client_id=app&state=x", true},
+		{"/device?user_code=BCDF-GHJK", true},
+		{"", false},
+		{"authorize", false},
+		{"//evil.example.com", false},
+		{"/\\evil.example.com", false},
+		{"https://evil.example.com/", false},
+		{"javascript:alert(1)", false},
+		{"/path\r\nSet-Cookie: x=y", false},
+	}
+
+	for _, tt := range tests {
+		if got := safeReturnPath(tt.target); got != tt.want {
+			t.Errorf("safeReturnPath(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
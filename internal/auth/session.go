@@ -1,15 +1,272 @@
 package auth
 
 import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Session lifetimes. A session expires after SessionIdleTimeout without
+// activity and never lives longer than SessionMaxLifetime. Configure
+// with SetSessionLifetimes before serving requests.
+var (
+	SessionIdleTimeout = 24 * time.Hour
+	SessionMaxLifetime = 7 * 24 * time.Hour
+)
+
+// Activity is recorded at most this often per session.
+const sessionTouchInterval = time.Minute
+
+// SetSessionLifetimes changes the idle timeout and absolute lifetime of
+// new and renewed sessions.
+func SetSessionLifetimes(idle, max time.Duration) {
+	SessionIdleTimeout = idle
+	SessionMaxLifetime = max
+}
+
+// endSession and endUserSessions end one session by ID and every
+// session of a user. By default the rows are simply deleted; see
+// SetSessionEnders.
+var (
+	endSession = func(db *sql.DB, sessionID string) error {
+		_, err := db.Exec("DELETE FROM sessions WHERE id=$1", sessionID)
+		return err
+	}
+	endUserSessions = func(db *sql.DB, userID int) error {
+		_, err := db.Exec("DELETE FROM sessions WHERE user_id=$1", userID)
+		return err
+	}
+)
+
+// SetSessionEnders routes the ending of sessions through end and
+// endUser, so that the clients signed in through them are notified the
+// way a logout would. The oidc package provides them; auth cannot
+// import it directly.
+func SetSessionEnders(end func(db *sql.DB, sessionID string) error, endUser func(db *sql.DB, userID int) error) {
+	endSession = end
+	endUserSessions = endUser
+}
+
 func NewSessionID() string {
 	return uuid.NewString()
 }
 
 func SessionExpiry() time.Time {
-	return time.Now().Add(24 * time.Hour)
+	return time.Now().Add(SessionIdleTimeout)
+}
+
+func setSessionCookie(w http.ResponseWriter, sessionID string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "sentinel_session",
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSessionCookie removes the sentinel_session cookie.
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "sentinel_session",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+	})
+}
+
+// StartSession stores a new session for the user and sets the
+// sentinel_session cookie. methods are the RFC 8176 amr values
+// describing how the user authenticated (e.g. "pwd", "hwk").
+//
+// Besides the secret cookie value each session gets a public sid,
+// which is what id_tokens and logout notifications refer to.
+//
+// If the request already carries a session for the same user, that
+// session is re-authenticated and rotated to a new ID instead, keeping
+// its sid so clients stay signed in.
+func StartSession(db *sql.DB, w http.ResponseWriter, r *http.Request, userID int, methods []string) error {
+	if cookie, err := r.Cookie("sentinel_session"); err == nil {
+		rotated, err := reauthenticateSession(db, w, r, cookie.Value, userID, methods)
+		if err != nil || rotated {
+			return err
+		}
+	}
+
+	sessionID := NewSessionID()
+	expires := SessionExpiry()
+	absolute := time.Now().Add(SessionMaxLifetime)
+
+	_, err := db.Exec(
+		`INSERT INTO sessions
+		 (id, user_id, expires_at, absolute_expires_at, auth_methods, authenticated_at, sid, user_agent, ip)
+		 VALUES ($1,$2,$3,$4,$5,now(),$6,$7,$8)`,
+		sessionID, userID, expires, absolute, strings.Join(methods, " "), uuid.NewString(),
+		r.UserAgent(), ClientIP(r),
+	)
+	if err != nil {
+		return err
+	}
+
+	setSessionCookie(w, sessionID, expires)
+	return nil
+}
+
+// reauthenticateSession records a fresh authentication on an existing
+// session of userID and rotates its ID. Sessions of other users are
+// ended. It reports whether the session was reused.
+func reauthenticateSession(db *sql.DB, w http.ResponseWriter, r *http.Request, oldID string, userID int, methods []string) (bool, error) {
+	newID := NewSessionID()
+	expires := SessionExpiry()
+
+	var applied time.Time
+	err := db.QueryRow(
+		`UPDATE sessions
+		 SET id=$1,
+		     auth_methods=$2,
+		     authenticated_at=now(),
+		     last_seen_at=now(),
+		     expires_at=LEAST($3, absolute_expires_at),
+		     user_agent=$4,
+		     ip=$5
+		 WHERE id=$6 AND user_id=$7 AND expires_at > now()
+		 RETURNING expires_at`,
+		newID, strings.Join(methods, " "), expires, r.UserAgent(), ClientIP(r), oldID, userID,
+	).Scan(&applied)
+	if err == sql.ErrNoRows {
+		// Not ours to keep: never let a login adopt a foreign session ID.
+		return false, endSession(db, oldID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	setSessionCookie(w, newID, applied)
+	return true, nil
+}
+
+// RotateSession gives the request's session a new ID, e.g. after its
+// privileges changed, and updates the cookie. The sid is unchanged.
+func RotateSession(db *sql.DB, w http.ResponseWriter, r *http.Request) error {
+	cookie, err := r.Cookie("sentinel_session")
+	if err != nil {
+		return err
+	}
+
+	newID := NewSessionID()
+
+	var expires time.Time
+	err = db.QueryRow(
+		"UPDATE sessions SET id=$1 WHERE id=$2 AND expires_at > now() RETURNING expires_at",
+		newID, cookie.Value,
+	).Scan(&expires)
+	if err != nil {
+		return err
+	}
+
+	setSessionCookie(w, newID, expires)
+	return nil
+}
+
+// TouchSession validates the request's session and slides its idle
+// expiry forward, never past the absolute lifetime. Writes are
+// throttled to one per sessionTouchInterval.
+func TouchSession(db *sql.DB, w http.ResponseWriter, r *http.Request) (bool, error) {
+	cookie, err := r.Cookie("sentinel_session")
+	if err != nil {
+		return false, nil
+	}
+
+	var expires, lastSeen time.Time
+	err = db.QueryRow(
+		"SELECT expires_at, last_seen_at FROM sessions WHERE id=$1",
+		cookie.Value,
+	).Scan(&expires, &lastSeen)
+	if err == sql.ErrNoRows || (err == nil && time.Now().After(expires)) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if time.Since(lastSeen) < sessionTouchInterval {
+		return true, nil
+	}
+
+	err = db.QueryRow(
+		`UPDATE sessions
+		 SET last_seen_at=now(), expires_at=LEAST($1, absolute_expires_at)
+		 WHERE id=$2
+		 RETURNING expires_at`,
+		SessionExpiry(), cookie.Value,
+	).Scan(&expires)
+	if err != nil {
+		return false, err
+	}
+
+	setSessionCookie(w, cookie.Value, expires)
+	return true, nil
+}
+
+// Session describes the login behind a session.
+type Session struct {
+	UserID          int
+	AuthMethods     string
+	AuthenticatedAt time.Time
+	SID             string
+	EmailVerified   bool
+}
+
+// CurrentSession returns the request's unexpired sentinel_session.
+func CurrentSession(db *sql.DB, r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie("sentinel_session")
+	if err != nil {
+		return nil, err
+	}
+
+	var s Session
+	err = db.QueryRowContext(r.Context(),
+		`SELECT s.user_id, s.auth_methods, s.authenticated_at, s.sid, u.email_verified
+		 FROM sessions s
+		 JOIN users u ON u.id = s.user_id
+		 WHERE s.id=$1 AND s.expires_at > now()`,
+		cookie.Value,
+	).Scan(&s.UserID, &s.AuthMethods, &s.AuthenticatedAt, &s.SID, &s.EmailVerified)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SessionUserID returns the user owning the request's sentinel_session.
+func SessionUserID(db *sql.DB, r *http.Request) (int, error) {
+	cookie, err := r.Cookie("sentinel_session")
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = db.QueryRow(
+		"SELECT user_id FROM sessions WHERE id=$1 AND expires_at > now()",
+		cookie.Value,
+	).Scan(&userID)
+
+	return userID, err
+}
+
+// ClientIP returns the address of the directly connected peer.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -0,0 +1,133 @@
+package dpop
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+// Proofs older than maxAge, or issued further than maxSkew in the
+// future, are rejected. Seen jtis are remembered for maxAge+maxSkew.
+const (
+	maxAge  = 5 * time.Minute
+	maxSkew = 30 * time.Second
+)
+
+var ErrInvalidProof = errors.New("invalid DPoP proof")
+
+// Verifier checks DPoP proof JWTs (RFC 9449) and rejects replays using
+// the dpop_proofs table. BaseURL is the scheme and host clients reach
+// the server at; a proof's htu must be BaseURL plus the request path.
+type Verifier struct {
+	DB      *sql.DB
+	BaseURL string
+}
+
+// Present reports whether r carries a DPoP header.
+func Present(r *http.Request) bool {
+	return len(r.Header.Values("DPoP")) > 0
+}
+
+// Verify validates the DPoP header of r and returns the JWK thumbprint
+// of the proof key. When accessToken is set (at a resource), the proof
+// must also carry its hash in ath.
+func (v *Verifier) Verify(ctx context.Context, r *http.Request, accessToken string) (string, error) {
+	values := r.Header.Values("DPoP")
+	if len(values) != 1 {
+		return "", ErrInvalidProof
+	}
+
+	var jwk *jwtutil.JWK
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(values[0], claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, errors.New("wrong typ")
+		}
+
+		k, err := jwtutil.ParseJWK(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		jwk = k
+		return k.PublicKey()
+	}, jwt.WithValidMethods(jwtutil.SigningMethods))
+	if err != nil {
+		return "", ErrInvalidProof
+	}
+
+	if htm, _ := claims["htm"].(string); htm != r.Method {
+		return "", ErrInvalidProof
+	}
+	if !sameURI(claims["htu"], v.BaseURL+r.URL.Path) {
+		return "", ErrInvalidProof
+	}
+
+	iat, _ := claims["iat"].(float64)
+	issued := time.Unix(int64(iat), 0)
+	if iat == 0 || time.Since(issued) > maxAge || time.Until(issued) > maxSkew {
+		return "", ErrInvalidProof
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", ErrInvalidProof
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		want := base64.RawURLEncoding.EncodeToString(sum[:])
+		ath, _ := claims["ath"].(string)
+		if subtle.ConstantTimeCompare([]byte(ath), []byte(want)) != 1 {
+			return "", ErrInvalidProof
+		}
+	}
+
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		return "", ErrInvalidProof
+	}
+
+	res, err := v.DB.ExecContext(ctx,
+		`INSERT INTO dpop_proofs (jkt, jti, expires_at)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		jkt, jti, time.Now().Add(maxAge+maxSkew),
+	)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrInvalidProof
+	}
+
+	return jkt, nil
+}
+
+// sameURI compares the htu claim with the expected URI, ignoring any
+// query and fragment as RFC 9449 requires.
+func sameURI(claim interface{}, want string) bool {
+	s, ok := claim.(string)
+	if !ok {
+		return false
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String() == want
+}
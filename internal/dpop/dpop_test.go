@@ -0,0 +1,98 @@
+package dpop
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSameURI(t *testing.T) {
+	const want = "https://idp.example.com/token"
+
+	tests := []struct {
+		name  string
+		claim interface{}
+		ok    bool
+	}{
+		{"exact", "https://idp.example.com/token", true},
+		{"query ignored", "https://idp.example.com/token?x=1", true},
+		{"fragment ignored", "https://idp.example.com/token#frag", true},
+		{"other path", "https://idp.example.com/userinfo", false},
+		{"trailing slash", "https://idp.example.com/token/", false},
+		{"other host", "https://evil.example.com/token", false},
+		{"other scheme", "http://idp.example.com/token", false},
+		{"relative", "/token", false},
+		{"not a string", 42, false},
+		{"missing", nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := sameURI(tt.claim, want); got != tt.ok {
+			t.Errorf("%s: sameURI = %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
+
+// proof returns a DPoP proof for htm and htu signed with a fresh
+// Ed25519 key.
+func proof(t *testing.T, htm, htu string) string {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"htm": htm,
+		"htu": htu,
+		"iat": time.Now().Unix(),
+		"jti": "proof-1",
+	})
+	tok.Header["typ"] = "dpop+jwt"
+	tok.Header["jwk"] = map[string]string{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(pub),
+	}
+
+	s, err := tok.SignedString(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// The method and URI are checked before the proof is recorded, so the
+// verifier needs no database to reject a mismatch.
+func TestVerifyRejectsMismatchedRequest(t *testing.T) {
+	v := &Verifier{BaseURL: "https://idp.example.com"}
+
+	tests := []struct {
+		name   string
+		htm    string
+		htu    string
+		method string
+		target string
+	}{
+		{"method", "GET", "https://idp.example.com/token", "POST", "/token"},
+		{"method case", "post", "https://idp.example.com/token", "POST", "/token"},
+		{"path", "POST", "https://idp.example.com/userinfo", "POST", "/token"},
+		{"host", "POST", "https://evil.example.com/token", "POST", "/token"},
+		{"internal host", "POST", "http://localhost:8080/token", "POST", "/token"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		r.Header.Set("DPoP", proof(t, tt.htm, tt.htu))
+
+		if _, err := v.Verify(context.Background(), r, ""); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("%s: err = %v, want ErrInvalidProof", tt.name, err)
+		}
+	}
+}
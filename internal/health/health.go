@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+// Handler serves liveness and readiness probes.
+type Handler struct {
+	DB         *sql.DB
+	KeyManager *jwtutil.KeyManager
+	// MaxKeyAge is how long ago the signing keys may have last been
+	// reloaded before the instance reports itself not ready.
+	MaxKeyAge time.Duration
+
+	draining atomic.Bool
+}
+
+// Drain makes Readyz fail so load balancers stop routing new requests
+// while in-flight ones finish.
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// Healthz reports that the process is up and serving.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+type check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readyz reports whether the instance can serve traffic: the database
+// answers, a signing key is active and the keys were reloaded recently.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]check)
+	ready := true
+
+	fail := func(name, msg string) {
+		checks[name] = check{Error: msg}
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := h.DB.PingContext(ctx); err != nil {
+		fail("database", err.Error())
+	} else {
+		checks["database"] = check{OK: true}
+	}
+
+	if h.KeyManager.ActiveKID() == "" {
+		fail("signing_key", "no active signing key")
+	} else {
+		checks["signing_key"] = check{OK: true}
+	}
+
+	age := time.Since(h.KeyManager.LastReload())
+	if h.MaxKeyAge > 0 && age > h.MaxKeyAge {
+		fail("key_reload", "last successful reload "+age.Round(time.Second).String()+" ago")
+	} else {
+		checks["key_reload"] = check{OK: true}
+	}
+
+	if h.draining.Load() {
+		fail("shutdown", "draining")
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":  ready,
+		"checks": checks,
+	})
+}
@@ -0,0 +1,114 @@
+package jwtutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"testing"
+)
+
+// The RSA key and thumbprint from RFC 7638 section 3.1.
+var rfc7638Key = JWK{
+	Kty: "RSA",
+	Kid: "2011-04-29",
+	Alg: "RS256",
+	N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECP" +
+		"ebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2Q" +
+		"vzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQF" +
+		"h6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	E: "AQAB",
+}
+
+const rfc7638Thumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+
+// The Ed25519 key and thumbprint from RFC 8037 appendix A.3.
+var rfc8037Key = JWK{
+	Kty: "OKP",
+	Crv: "Ed25519",
+	X:   "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+}
+
+const rfc8037Thumbprint = "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"
+
+func TestThumbprint(t *testing.T) {
+	tests := []struct {
+		name string
+		key  JWK
+		want string
+	}{
+		{"RFC 7638 RSA", rfc7638Key, rfc7638Thumbprint},
+		{"RFC 8037 Ed25519", rfc8037Key, rfc8037Thumbprint},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.key.Thumbprint()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: thumbprint = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestThumbprintIgnoresOptionalMembers(t *testing.T) {
+	k := rfc7638Key
+	k.Kid, k.Alg, k.Use = "", "", ""
+
+	got, err := k.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != rfc7638Thumbprint {
+		t.Errorf("thumbprint = %s, want %s", got, rfc7638Thumbprint)
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	pub, err := rfc7638Key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok || rsaKey.N.BitLen() != 2048 || rsaKey.E != 65537 {
+		t.Errorf("RSA key = %#v", pub)
+	}
+
+	pub, err = rfc8037Key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := pub.(ed25519.PublicKey); !ok || len(k) != ed25519.PublicKeySize {
+		t.Errorf("Ed25519 key = %#v", pub)
+	}
+}
+
+func TestPublicKeyRejects(t *testing.T) {
+	private := rfc8037Key
+	private.D = "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"
+
+	offCurve := JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   "AQ",
+		Y:   "AQ",
+	}
+
+	small := rfc7638Key
+	small.N = "AQAB"
+
+	tests := []struct {
+		name string
+		key  JWK
+	}{
+		{"private key", private},
+		{"point off curve", offCurve},
+		{"short RSA modulus", small},
+		{"unknown kty", JWK{Kty: "oct"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.key.PublicKey(); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
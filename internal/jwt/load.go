@@ -8,7 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
-
+	"time"
 )
 
 type Loader struct {
@@ -127,5 +127,6 @@ func LoadKeys(db *sql.DB) (*KeyManager, error) {
 		return nil, errors.New("no active signing key")
 	}
 
+	km.loadedAt = time.Now()
 	return km, nil
 }
@@ -0,0 +1,97 @@
+package jwtutil
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+// AccessTokenOption customises a token minted by MintAccessToken.
+type AccessTokenOption func(claims map[string]interface{})
+
+// WithCertThumbprint binds the token to a client certificate (RFC 8705)
+// by adding a cnf claim with its x5t#S256 thumbprint.
+func WithCertThumbprint(thumbprint string) AccessTokenOption {
+	return func(claims map[string]interface{}) {
+		if thumbprint == "" {
+			return
+		}
+		confirmation(claims)["x5t#S256"] = thumbprint
+	}
+}
+
+// WithDPoPKey binds the token to a DPoP key (RFC 9449) by adding a cnf
+// claim with its JWK thumbprint.
+func WithDPoPKey(jkt string) AccessTokenOption {
+	return func(claims map[string]interface{}) {
+		if jkt == "" {
+			return
+		}
+		confirmation(claims)["jkt"] = jkt
+	}
+}
+
+// WithAudience replaces the token's aud, which defaults to the issuer
+// (Sentinel's own APIs).
+func WithAudience(aud string) AccessTokenOption {
+	return func(claims map[string]interface{}) {
+		if aud == "" {
+			return
+		}
+		claims["aud"] = aud
+	}
+}
+
+// WithScopes replaces the user's scopes with scopes, e.g. when a token
+// is narrowed for another audience.
+func WithScopes(scopes []string) AccessTokenOption {
+	return func(claims map[string]interface{}) {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+}
+
+// WithAllowedScopes drops the scopes not in allowed, e.g. those a
+// resource server does not accept.
+func WithAllowedScopes(allowed []string) AccessTokenOption {
+	return func(claims map[string]interface{}) {
+		granted, _ := claims["scope"].(string)
+
+		var kept []string
+		for _, s := range strings.Fields(granted) {
+			if slices.Contains(allowed, s) {
+				kept = append(kept, s)
+			}
+		}
+		claims["scope"] = strings.Join(kept, " ")
+	}
+}
+
+// WithActor adds an act claim (RFC 8693) naming the party acting on
+// behalf of the subject.
+func WithActor(act map[string]interface{}) AccessTokenOption {
+	return func(claims map[string]interface{}) {
+		if act == nil {
+			return
+		}
+		claims["act"] = act
+	}
+}
+
+// confirmation returns the token's cnf claim, creating it if needed.
+func confirmation(claims map[string]interface{}) map[string]interface{} {
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		cnf = make(map[string]interface{})
+		claims["cnf"] = cnf
+	}
+	return cnf
+}
+
+// CertThumbprint returns the base64url SHA-256 thumbprint of cert's DER
+// encoding, as used in x5t#S256.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
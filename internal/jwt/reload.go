@@ -2,7 +2,7 @@ package jwtutil
 
 import (
 	"database/sql"
-	
+	"time"
 )
 
 func (km *KeyManager) ReloadFromDB(db *sql.DB) error {
@@ -17,6 +17,23 @@ func (km *KeyManager) ReloadFromDB(db *sql.DB) error {
 	km.privateKeys = newKM.privateKeys
 	km.publicKeys = newKM.publicKeys
 	km.activeKID = newKM.activeKID
+	km.loadedAt = newKM.loadedAt
 
 	return nil
 }
+
+// ActiveKID returns the kid currently used for signing.
+func (km *KeyManager) ActiveKID() string {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	return km.activeKID
+}
+
+// LastReload returns when the keys were last loaded successfully.
+func (km *KeyManager) LastReload() time.Time {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	return km.loadedAt
+}
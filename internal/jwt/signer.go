@@ -1,6 +1,7 @@
 package jwtutil
 
 import (
+	"context"
 	"crypto/rsa"
 	"database/sql"
 	"errors"
@@ -11,6 +12,23 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/metrics"
+	"github.com/SAMurai-16/sentinel-idp/internal/tracing"
+)
+
+// AccessTokenTTL is the lifetime of access tokens minted by Signer.
+const AccessTokenTTL = 15 * time.Minute
+
+// Token types set in the typ header, so one kind of token cannot be
+// passed off as another.
+const (
+	AccessTokenType = "at+jwt"
+	LogoutTokenType = "logout+jwt"
 )
 
 type Signer struct {
@@ -27,13 +45,46 @@ type KeyManager struct {
 	privateKeys map[string]*rsa.PrivateKey
 	publicKeys  map[string]*rsa.PublicKey
 	activeKID  string
+	loadedAt   time.Time
+}
+
+
+
+
+// startSpan starts a span for a Signer operation.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
 
+// sign signs token with priv, recording the latency per kid.
+func sign(ctx context.Context, token *jwt.Token, priv *rsa.PrivateKey, kid, tokenType string) (string, error) {
+	_, span := startSpan(ctx, "jwt.sign",
+		attribute.String("jwt.kid", kid),
+		attribute.String("jwt.token_type", tokenType),
+	)
+	start := time.Now()
+	defer func() {
+		metrics.SigningDuration.WithLabelValues(kid, tokenType).Observe(time.Since(start).Seconds())
+		span.End()
+	}()
 
+	return token.SignedString(priv)
+}
 
+func getScopesForUser(ctx context.Context, db *sql.DB, userID int) ([]string, error) {
+	ctx, span := startSpan(ctx, "jwt.getScopesForUser")
+	defer span.End()
 
-func getScopesForUser(db *sql.DB, userID int) ([]string, error) {
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT s.name
 		FROM scopes s
 		JOIN role_scopes rs ON rs.scope_id = s.id
@@ -55,7 +106,10 @@ func getScopesForUser(db *sql.DB, userID int) ([]string, error) {
 }
 
 
-func (s *Signer) MintAccessToken(userID int, clientID string) (string, error) {
+func (s *Signer) MintAccessToken(ctx context.Context, userID int, clientID string, opts ...AccessTokenOption) (token string, err error) {
+	ctx, span := startSpan(ctx, "jwt.MintAccessToken", attribute.String("oauth.client_id", clientID))
+	defer func() { endSpan(span, err) }()
+
 	now := time.Now()
 
 	s.KeyManager.mu.RLock()
@@ -63,7 +117,7 @@ func (s *Signer) MintAccessToken(userID int, clientID string) (string, error) {
 	priv := s.KeyManager.privateKeys[kid]
 	s.KeyManager.mu.RUnlock()
 
-	scopes, _ := getScopesForUser(s.DB, userID)
+	scopes, _ := getScopesForUser(ctx, s.DB, userID)
 
 	if priv == nil {
 		return "", errors.New("active signing key not found")
@@ -72,22 +126,29 @@ func (s *Signer) MintAccessToken(userID int, clientID string) (string, error) {
 	claims := jwt.MapClaims{
 		"iss": s.Issuer,
 		"sub": userID,
-		"aud": clientID,
+		"aud": s.Issuer,
 		"iat": now.Unix(),
-		"exp": now.Add(15 * time.Minute).Unix(),
+		"exp": now.Add(AccessTokenTTL).Unix(),
 		"jti": uuid.NewString(),
 		"scope": strings.Join(scopes, " "),
+		"client_id": clientID,
+	}
+	for _, opt := range opts {
+		opt(claims)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	token.Header["kid"] = kid
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = kid
+	t.Header["typ"] = AccessTokenType
 
-	return token.SignedString(priv)
+	return sign(ctx, t, priv, kid, "access_token")
 }
 
 
 
-func (s *Signer) MintIDToken(userID int, clientID string, authTime time.Time) (string, error) {
+func (s *Signer) MintIDToken(ctx context.Context, userID int, clientID string, authTime time.Time, amr []string, sid string) (token string, err error) {
+	ctx, span := startSpan(ctx, "jwt.MintIDToken", attribute.String("oauth.client_id", clientID))
+	defer func() { endSpan(span, err) }()
 
 	s.KeyManager.mu.RLock()
 	kid := s.KeyManager.activeKID
@@ -95,7 +156,7 @@ func (s *Signer) MintIDToken(userID int, clientID string, authTime time.Time) (s
 	s.KeyManager.mu.RUnlock()
 
 	var username string
-	err := s.DB.QueryRow(
+	err = s.DB.QueryRowContext(ctx,
 		`SELECT username FROM users WHERE id=$1`,
 		userID,
 	).Scan(&username)
@@ -116,10 +177,49 @@ func (s *Signer) MintIDToken(userID int, clientID string, authTime time.Time) (s
 		"exp":       now.Add(15 * time.Minute).Unix(),
 		"iat":       now.Unix(),
 		"auth_time": authTime.Unix(),
+		"amr":       amr,
+		"sid":       sid,
 
 		"preferred_username": username,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(priv)
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = kid
+	return sign(ctx, t, priv, kid, "id_token")
+}
+
+// MintLogoutToken creates an OIDC Back-Channel Logout token telling
+// clientID that session sid of userID has ended.
+func (s *Signer) MintLogoutToken(ctx context.Context, userID int, clientID string, sid string) (token string, err error) {
+	ctx, span := startSpan(ctx, "jwt.MintLogoutToken", attribute.String("oauth.client_id", clientID))
+	defer func() { endSpan(span, err) }()
+
+	s.KeyManager.mu.RLock()
+	kid := s.KeyManager.activeKID
+	priv := s.KeyManager.privateKeys[kid]
+	s.KeyManager.mu.RUnlock()
+
+	if priv == nil {
+		return "", errors.New("active signing key not found")
+	}
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"iss": s.Issuer,
+		"sub": userID,
+		"aud": clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(2 * time.Minute).Unix(),
+		"jti": uuid.NewString(),
+		"sid": sid,
+		"events": map[string]interface{}{
+			"http://schemas.openid.net/event/backchannel-logout": map[string]interface{}{},
+		},
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = kid
+	t.Header["typ"] = LogoutTokenType
+	return sign(ctx, t, priv, kid, "logout_token")
 }
@@ -0,0 +1,78 @@
+package jwtutil
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Keyfunc resolves the public key for a token signed by this
+// KeyManager. Tokens without a kid are checked against the active key.
+func (km *KeyManager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	if kid == "" {
+		kid = km.activeKID
+	}
+
+	pub, ok := km.publicKeys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	return pub, nil
+}
+
+// ParseToken verifies the signature of a token issued by Sentinel and
+// returns its claims. Extra parser options (e.g. jwt.WithIssuer) are
+// applied on top of RS256-only validation.
+func (km *KeyManager) ParseToken(tokenStr string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	t, err := km.parse(tokenStr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return t.Claims.(jwt.MapClaims), nil
+}
+
+// ParseAccessToken is ParseToken for access tokens: the token must carry
+// the at+jwt type (RFC 9068), so ID and logout tokens signed with the
+// same keys are not accepted in their place.
+func (km *KeyManager) ParseAccessToken(tokenStr string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	t, err := km.parse(tokenStr, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !hasType(t, AccessTokenType) {
+		return nil, errors.New("not an access token")
+	}
+	return t.Claims.(jwt.MapClaims), nil
+}
+
+// ParseIDToken is ParseToken for ID tokens, which are minted without an
+// explicit type: tokens typed as anything but a plain JWT, such as
+// access and logout tokens, are rejected.
+func (km *KeyManager) ParseIDToken(tokenStr string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	t, err := km.parse(tokenStr, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.Header["typ"]; ok && !hasType(t, "jwt") {
+		return nil, errors.New("not an ID token")
+	}
+	return t.Claims.(jwt.MapClaims), nil
+}
+
+func (km *KeyManager) parse(tokenStr string, opts []jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{"RS256"}))
+	return jwt.ParseWithClaims(tokenStr, jwt.MapClaims{}, km.Keyfunc, opts...)
+}
+
+// hasType reports whether t's typ header is typ, with or without the
+// "application/" prefix (RFC 8725 section 3.11).
+func hasType(t *jwt.Token, typ string) bool {
+	got, _ := t.Header["typ"].(string)
+	return strings.TrimPrefix(strings.ToLower(got), "application/") == typ
+}
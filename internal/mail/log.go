@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSender is a development Sender. Messages are appended to Path, or
+// written to the standard logger when Path is empty.
+type LogSender struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *LogSender) Send(to, subject, body string) error {
+	entry := fmt.Sprintf(
+		"--- %s\nTo: %s\nSubject: %s\n\n%s\n",
+		time.Now().Format(time.RFC3339), to, subject, body,
+	)
+
+	if s.Path == "" {
+		log.Print(entry)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(entry)
+	return err
+}
@@ -0,0 +1,6 @@
+package mail
+
+// Sender delivers a plain-text message to a single recipient.
+type Sender interface {
+	Send(to, subject, body string) error
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every Sentinel metric. It is served by Handler.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentinel_http_requests_total",
+		Help: "HTTP requests by endpoint, method and status code.",
+	}, []string{"endpoint", "method", "code"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sentinel_http_request_duration_seconds",
+		Help:    "HTTP request latency by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	TokenRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentinel_token_requests_total",
+		Help: "Token endpoint requests by grant_type and outcome.",
+	}, []string{"grant_type", "outcome"})
+
+	TokenDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sentinel_token_request_duration_seconds",
+		Help:    "Token endpoint latency by grant_type.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"grant_type"})
+
+	SigningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sentinel_token_signing_duration_seconds",
+		Help:    "Time spent signing JWTs by kid and token type.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+	}, []string{"kid", "token_type"})
+
+	RefreshReuseDetected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sentinel_refresh_token_reuse_detected_total",
+		Help: "Rotated-out refresh tokens presented again.",
+	})
+
+	KeyReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentinel_signing_key_reloads_total",
+		Help: "Signing key reloads from the database by result.",
+	}, []string{"result"})
+
+	RowsReaped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentinel_janitor_rows_reaped_total",
+		Help: "Expired rows deleted by the janitor by table.",
+	}, []string{"table"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		TokenRequests,
+		TokenDuration,
+		SigningDuration,
+		RefreshReuseDetected,
+		KeyReloads,
+		RowsReaped,
+	)
+}
+
+// RegisterDB exports connection pool statistics for db.
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, "sentinel"))
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Instrument counts and times requests to next under the endpoint
+// label. Use the route pattern, not the raw path, to bound cardinality.
+func Instrument(endpoint string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		HTTPDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		HTTPRequests.WithLabelValues(endpoint, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+var knownGrantTypes = map[string]bool{
+	"authorization_code": true,
+	"refresh_token":      true,
+	"urn:ietf:params:oauth:grant-type:device_code":    true,
+	"urn:ietf:params:oauth:grant-type:token-exchange": true,
+	"urn:ietf:params:oauth:grant-type:jwt-bearer":     true,
+}
+
+// InstrumentToken is Instrument for the token endpoint, additionally
+// breaking requests down by grant_type and outcome.
+func InstrumentToken(next http.Handler) http.Handler {
+	return Instrument("/token", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		// The token handler has parsed the form by now.
+		grantType := r.PostForm.Get("grant_type")
+		if !knownGrantTypes[grantType] {
+			grantType = "other"
+		}
+
+		TokenDuration.WithLabelValues(grantType).Observe(time.Since(start).Seconds())
+		TokenRequests.WithLabelValues(grantType, outcome(rec.status)).Inc()
+	}))
+}
+
+func outcome(status int) string {
+	switch {
+	case status < 400:
+		return "success"
+	case status < 500:
+		return "client_error"
+	default:
+		return "server_error"
+	}
+}
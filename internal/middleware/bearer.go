@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/dpop"
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+type claimsKey struct{}
+
+// ErrInvalidAccessToken is returned by ParseAccessToken for tokens that
+// are not valid, unrevoked Sentinel access tokens.
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// ParseAccessToken validates a Sentinel access token: an at+jwt signed
+// by km for issuer, unexpired, with a jti and client_id, and not
+// revoked. opts add parser checks, e.g. jwt.WithAudience. Errors other
+// than ErrInvalidAccessToken come from the database.
+func ParseAccessToken(ctx context.Context, db *sql.DB, km *jwtutil.KeyManager, issuer, token string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	opts = append(opts, jwt.WithIssuer(issuer), jwt.WithExpirationRequired())
+
+	claims, err := km.ParseAccessToken(token, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAccessToken, err)
+	}
+
+	// Without a jti the token could never be found in revoked_tokens.
+	jti, _ := claims["jti"].(string)
+	clientID, _ := claims["client_id"].(string)
+	if jti == "" || clientID == "" {
+		return nil, fmt.Errorf("%w: missing jti or client_id", ErrInvalidAccessToken)
+	}
+
+	var revoked bool
+	err = db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)",
+		jti,
+	).Scan(&revoked)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: revoked", ErrInvalidAccessToken)
+	}
+	return claims, nil
+}
+
+// ClaimsFromContext returns the access token claims stored by
+// RequireScope.
+func ClaimsFromContext(ctx context.Context) jwt.MapClaims {
+	claims, _ := ctx.Value(claimsKey{}).(jwt.MapClaims)
+	return claims
+}
+
+// RequireScope only lets requests through that carry a valid, unrevoked
+// Sentinel access token (Authorization: Bearer) granting scope.
+func RequireScope(db *sql.DB, km *jwtutil.KeyManager, verifier *dpop.Verifier, issuer, scope string, next http.Handler) http.Handler {
+	return RequireAccessToken(db, km, verifier, issuer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		granted, _ := ClaimsFromContext(r.Context())["scope"].(string)
+		if !hasScope(granted, scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			http.Error(w, "insufficient scope", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// RequireAccessToken only lets requests through that carry a valid,
+// unrevoked Sentinel access token addressed to issuer (Authorization:
+// Bearer, or DPoP for DPoP-bound tokens). Tokens bound to a client certificate are only
+// accepted over a TLS connection presenting that certificate, and
+// DPoP-bound tokens only with a fresh proof from their key, checked by
+// verifier.
+func RequireAccessToken(db *sql.DB, km *jwtutil.KeyManager, verifier *dpop.Verifier, issuer string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		scheme, tokenStr, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if (scheme != "Bearer" && scheme != "DPoP") || tokenStr == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			http.Error(w, "missing access token", http.StatusUnauthorized)
+			return
+		}
+
+		invalid := func(msg string) {
+			w.Header().Set("WWW-Authenticate", scheme+` error="invalid_token"`)
+			http.Error(w, msg, http.StatusUnauthorized)
+		}
+
+		// Tokens addressed to another resource are not for Sentinel's
+		// own APIs.
+		claims, err := ParseAccessToken(r.Context(), db, km, issuer, tokenStr, jwt.WithAudience(issuer))
+		if errors.Is(err, ErrInvalidAccessToken) {
+			invalid("invalid access token")
+			return
+		}
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		if !CertBindingMatches(claims, r) {
+			invalid("access token is bound to a different certificate")
+			return
+		}
+
+		// A DPoP-bound token must not be downgraded to a bearer token.
+		cnf, _ := claims["cnf"].(map[string]interface{})
+		jkt, _ := cnf["jkt"].(string)
+		if (jkt != "") != (scheme == "DPoP") {
+			invalid("access token scheme does not match its binding")
+			return
+		}
+		if jkt != "" {
+			got, err := verifier.Verify(r.Context(), r, tokenStr)
+			if err != nil || got != jkt {
+				w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+				http.Error(w, "invalid DPoP proof", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	})
+}
+
+// CertBindingMatches reports whether a token's cnf x5t#S256 claim, if
+// any, matches the client certificate presented on r (RFC 8705).
+func CertBindingMatches(claims jwt.MapClaims, r *http.Request) bool {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	want, _ := cnf["x5t#S256"].(string)
+	if want == "" {
+		return true
+	}
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	got := jwtutil.CertThumbprint(r.TLS.PeerCertificates[0])
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+func hasScope(granted, want string) bool {
+	for _, s := range strings.Fields(granted) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import "net/http"
+
+// RequireClientCert rejects requests that did not present a client
+// certificate during the TLS handshake. Whether the certificate is
+// acceptable is up to the handler, e.g. client authentication at /token.
+func RequireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"log"
 	"net/http"
 )
@@ -26,7 +28,11 @@ func RequireCSRF(next http.Handler) http.Handler {
 			return
 		}
 
+		// HTML forms can't set headers, so they send the token as a field.
 		csrfHeader := r.Header.Get("X-CSRF-Token")
+		if csrfHeader == "" {
+			csrfHeader = r.PostFormValue("csrf_token")
+		}
 		if csrfHeader == "" || csrfHeader != csrfCookie.Value {
 			http.Error(w, "invalid csrf token", http.StatusForbidden)
 			return
@@ -39,3 +45,22 @@ func RequireCSRF(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+
+// IssueCSRFToken sets a fresh csrf_token cookie and returns its value
+// for embedding in a form or header.
+func IssueCSRFToken(w http.ResponseWriter) string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return token
+}
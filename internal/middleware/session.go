@@ -2,32 +2,25 @@ package middleware
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
-	"time"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/auth"
 )
 
 func RequireSession(db *sql.DB, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		cookie, err := r.Cookie("sentinel_session")
+		valid, err := auth.TouchSession(db, w, r)
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusFound)
+			log.Println("session check failed:", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
 			return
 		}
 
-		var expires time.Time
-		err = db.QueryRow(
-			"SELECT expires_at FROM sessions WHERE id = $1",
-			cookie.Value,
-		).Scan(&expires)
-
-		if err != nil || time.Now().After(expires) {
-			http.SetCookie(w, &http.Cookie{
-				Name:   "sentinel_session",
-				Value:  "",
-				MaxAge: -1,
-			})
-			http.Redirect(w, r, "/login", http.StatusFound)
+		if !valid {
+			auth.ClearSessionCookie(w)
+			auth.RedirectToLogin(db, w, r)
 			return
 		}
 
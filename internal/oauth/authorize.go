@@ -4,14 +4,33 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"errors"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
+	"github.com/SAMurai-16/sentinel-idp/internal/auth"
+	"github.com/SAMurai-16/sentinel-idp/internal/oidc"
 )
 
 type AuthorizeHandler struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Audit *audit.Logger
+	// Issuer is the audience request objects must be addressed to.
+	Issuer string
+	// Clients provides the keys request objects are signed with.
+	Clients *ClientAuthenticator
+	// HTTPClient fetches request objects passed by reference.
+	HTTPClient *http.Client
+	// RequirePAR rejects authorization requests from every client that
+	// were not pushed to /par first.
+	RequirePAR bool
 }
 
 func randomCode() string {
@@ -20,60 +39,310 @@ func randomCode() string {
 	return base64.RawURLEncoding.EncodeToString(b)
 }
 
+// redirectWithParams sends the user agent back to the client's
+// redirect_uri with params added to its query string.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			if v != "" {
+				q.Add(k, v)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// redirectError reports an authorization error to the client as
+// described in RFC 6749 section 4.1.2.1.
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI, code, state string) {
+	redirectWithParams(w, r, redirectURI, url.Values{
+		"error": {code},
+		"state": {state},
+	})
+}
+
+// parsePrompt splits the OIDC prompt parameter. "none" may not be
+// combined with any other value.
+func parsePrompt(raw string) (map[string]bool, error) {
+	prompt := make(map[string]bool)
+	for _, v := range strings.Fields(raw) {
+		switch v {
+		case "none", "login", "consent", "select_account":
+			prompt[v] = true
+		default:
+			return nil, errors.New("unsupported prompt value")
+		}
+	}
+
+	if prompt["none"] && len(prompt) > 1 {
+		return nil, errors.New("prompt=none cannot be combined with other values")
+	}
+
+	return prompt, nil
+}
+
+// parseMaxAge parses the OIDC max_age parameter, in seconds. It returns
+// -1 when the parameter is absent.
+func parseMaxAge(raw string) (int, error) {
+	if raw == "" {
+		return -1, nil
+	}
+	maxAge, err := strconv.Atoi(raw)
+	if err != nil || maxAge < 0 {
+		return 0, errors.New("invalid max_age")
+	}
+	return maxAge, nil
+}
+
+// authenticatedWithin reports whether a login at authenticatedAt
+// satisfies maxAge (as returned by parseMaxAge).
+func authenticatedWithin(authenticatedAt time.Time, maxAge int) bool {
+	return maxAge < 0 || time.Since(authenticatedAt) <= time.Duration(maxAge)*time.Second
+}
+
+// reauthenticate sends the user to /login and resumes this request
+// afterwards. prompt=login and max_age are dropped from the resumed
+// request, since the fresh login satisfies them. Pushed and signed
+// requests are resumed by reference to a pushed request, so their
+// parameters stay off the URL and are not verified again.
+func (h *AuthorizeHandler) reauthenticate(w http.ResponseWriter, r *http.Request, req *authorizationRequest, prompt map[string]bool, loginHint string) {
+	q := url.Values{}
+	for k, v := range req.params {
+		q[k] = v
+	}
+	q.Del("request")
+	q.Del("request_uri")
+	q.Del("max_age")
+	q.Del("prompt")
+
+	var rest []string
+	for v := range prompt {
+		if v != "login" {
+			rest = append(rest, v)
+		}
+	}
+	if len(rest) > 0 {
+		q.Set("prompt", strings.Join(rest, " "))
+	}
+
+	q, err := h.resumable(r, req, q)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	auth.SaveReturnTo(h.DB, w, r.URL.Path+"?"+q.Encode())
+
+	target := "/login"
+	if loginHint != "" {
+		target += "?" + url.Values{"login_hint": {loginHint}}.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// resumable returns the query that resumes req with parameters q.
+// Pushed and signed requests are referred to by a pushed request that
+// is kept alive for parLoginWindow.
+func (h *AuthorizeHandler) resumable(r *http.Request, req *authorizationRequest, q url.Values) (url.Values, error) {
+	if req.pushedURI == "" && !req.signed {
+		return q, nil
+	}
+
+	requestURI := req.pushedURI
+
+	var err error
+	if requestURI != "" {
+		err = h.holdPushedRequest(r, requestURI, q)
+	} else {
+		requestURI, err = storePushedRequest(r.Context(), h.DB, q.Get("client_id"), q, parLoginWindow)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return url.Values{"client_id": {q.Get("client_id")}, "request_uri": {requestURI}}, nil
+}
+
 func (h *AuthorizeHandler) Authorize(w http.ResponseWriter, r *http.Request) {
-	// 1. Parse params
+	// 1. Parse params, from /par or a request object if given
 	clientID := r.URL.Query().Get("client_id")
-	redirectURI := r.URL.Query().Get("redirect_uri")
-	codeChallenge := r.URL.Query().Get("code_challenge")
-	codeChallengeMethod := r.URL.Query().Get("code_challenge_method")
-	state := r.URL.Query().Get("state")
+	if clientID == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req, err := h.resolveRequest(r, clientID)
+	if err != nil {
+		log.Println("authorization request rejected:", err)
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	params := req.params
+
+	redirectURI := params.Get("redirect_uri")
+	codeChallenge := params.Get("code_challenge")
+	codeChallengeMethod := params.Get("code_challenge_method")
+	state := params.Get("state")
+	loginHint := params.Get("login_hint")
 
-	if clientID == "" || redirectURI == "" {
+	if redirectURI == "" {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
 
 	// 2. Validate client + redirect URI
-	var dbRedirect string
-	err := h.DB.QueryRow(
-		"SELECT redirect_uri FROM oauth_clients WHERE client_id=$1",
+	var (
+		dbRedirect     string
+		requirePAR     bool
+		requireConsent bool
+	)
+	err = h.DB.QueryRowContext(r.Context(),
+		`SELECT redirect_uri, require_pushed_authorization_requests, require_consent
+		 FROM oauth_clients WHERE client_id=$1`,
 		clientID,
-	).Scan(&dbRedirect)
+	).Scan(&dbRedirect, &requirePAR, &requireConsent)
 
 	if err != nil || dbRedirect != redirectURI {
 		http.Error(w, "invalid client", http.StatusBadRequest)
 		return
 	}
 
+	// From here on errors go back to the client's redirect_uri.
+
+	if (requirePAR || h.RequirePAR) && req.pushedURI == "" {
+		redirectError(w, r, redirectURI, "invalid_request", state)
+		return
+	}
+
 	// 3. Validate PKCE
 	if err := ValidatePKCE(codeChallenge, codeChallengeMethod); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		redirectError(w, r, redirectURI, "invalid_request", state)
 		return
 	}
 
-	// 4. Get logged-in user (session already enforced by middleware)
-	cookie, _ := r.Cookie("sentinel_session")
+	prompt, err := parsePrompt(params.Get("prompt"))
+	if err != nil {
+		redirectError(w, r, redirectURI, "invalid_request", state)
+		return
+	}
 
-	var userID int
-	err = h.DB.QueryRow(
-		"SELECT user_id FROM sessions WHERE id=$1",
-		cookie.Value,
-	).Scan(&userID)
+	// Resource indicators (RFC 8707) the tokens may be addressed to.
+	resources := params["resource"]
+	if err := checkResources(r.Context(), h.DB, resources); errors.Is(err, errInvalidTarget) {
+		redirectError(w, r, redirectURI, "invalid_target", state)
+		return
+	} else if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
 
+	maxAge, err := parseMaxAge(params.Get("max_age"))
 	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusFound)
+		redirectError(w, r, redirectURI, "invalid_request", state)
+		return
+	}
+
+	// 4. Get logged-in user
+	session, err := auth.CurrentSession(h.DB, r)
+	loggedIn := err == nil
+	if !loggedIn {
+		session = &auth.Session{}
+	}
+	userID, authMethods, authenticatedAt := session.UserID, session.AuthMethods, session.AuthenticatedAt
+	emailVerified, sid := session.EmailVerified, session.SID
+	fresh := authenticatedWithin(authenticatedAt, maxAge)
+
+	if prompt["none"] {
+		if !loggedIn || !fresh {
+			redirectError(w, r, redirectURI, "login_required", state)
+			return
+		}
+		if !emailVerified {
+			redirectError(w, r, redirectURI, "interaction_required", state)
+			return
+		}
+	}
+
+	if !loggedIn {
+		if _, err := r.Cookie("sentinel_session"); err == nil {
+			auth.ClearSessionCookie(w)
+		}
+		h.reauthenticate(w, r, req, prompt, loginHint)
+		return
+	}
+
+	if prompt["login"] || !fresh {
+		h.reauthenticate(w, r, req, prompt, loginHint)
 		return
 	}
 
-	// 5. Issue authorization code
+	if !emailVerified {
+		http.Error(w, "email address not verified", http.StatusForbidden)
+		return
+	}
+
+	// 5. Consent, for clients that require it or when prompt=consent.
+	// The consent page posts the user's decision back here.
+	if r.Method == http.MethodPost {
+		if r.PostFormValue("consent") != "allow" {
+			redirectError(w, r, redirectURI, "access_denied", state)
+			return
+		}
+		if err := h.grantConsent(r, userID, clientID); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		needConsent := prompt["consent"]
+		if !needConsent && requireConsent {
+			consented, err := h.hasConsent(r, userID, clientID)
+			if err != nil {
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			needConsent = !consented
+		}
+
+		if needConsent {
+			if prompt["none"] {
+				redirectError(w, r, redirectURI, "consent_required", state)
+				return
+			}
+			h.askConsent(w, r, req, prompt)
+			return
+		}
+	}
+
+	// 6. Issue authorization code, once per pushed request
+	if req.pushedURI != "" {
+		ok, err := h.consumePushedRequest(r, req.pushedURI)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "invalid request_uri", http.StatusBadRequest)
+			return
+		}
+	}
+
 	code := randomCode()
 	expires := time.Now().Add(60 * time.Second)
 
-	_, err = h.DB.Exec(
+	_, err = h.DB.ExecContext(r.Context(),
 		`INSERT INTO authorization_codes
-		 (code, client_id, user_id, code_challenge, expires_at)
-		 VALUES ($1,$2,$3,$4,$5)`,
-		code, clientID, userID, codeChallenge, expires,
+		 (code, client_id, user_id, code_challenge, expires_at, auth_methods, auth_time, sid, resources)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		code, clientID, userID, codeChallenge, expires, authMethods, authenticatedAt, sid, strings.Join(resources, " "),
 	)
 
 	if err != nil {
@@ -81,13 +350,18 @@ func (h *AuthorizeHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 6. Redirect back to client
-	http.Redirect(
-		w,
-		r,
-		redirectURI+"?code="+code+"&state="+state,
-		http.StatusFound,
-	)
+	h.Audit.Record(r, audit.Event{
+		Type:     audit.CodeIssued,
+		UserID:   audit.User(userID),
+		ClientID: clientID,
+		Details:  map[string]interface{}{"sid": sid},
+	})
+
+	// 7. Redirect back to client
+	redirectWithParams(w, r, redirectURI, url.Values{
+		"code":  {code},
+		"state": {state},
+	})
 }
 
 func (h *AuthorizeHandler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -95,6 +369,15 @@ func (h *AuthorizeHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	return
 	}
+	// End the IdP session as well as the access token.
+	if session, err := r.Cookie("sentinel_session"); err == nil {
+		if _, err := oidc.EndSession(h.DB, session.Value); err != nil {
+			log.Println("session logout failed:", err)
+		}
+
+		auth.ClearSessionCookie(w)
+	}
+
 	cookie, err := r.Cookie("sentinel_access")
 	if err != nil {
 		w.WriteHeader(http.StatusNoContent)
@@ -113,12 +396,20 @@ func (h *AuthorizeHandler) Logout(w http.ResponseWriter, r *http.Request) {
 					"INSERT INTO revoked_tokens (jti) VALUES ($1) ON CONFLICT DO NOTHING",
 					jti,
 				)
+
+				e := audit.Event{
+					Type:    audit.TokenRevoked,
+					Details: map[string]interface{}{"jti": jti, "reason": "logout"},
+				}
+				e.ClientID = tokenClientID(claims)
+				if sub, ok := claims["sub"].(float64); ok {
+					e.UserID = audit.User(int(sub))
+				}
+				h.Audit.Record(r, e)
 			}
 		}
 	}
 
-	print("token revoked")
-
 	// Delete cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "sentinel_access",
@@ -0,0 +1,86 @@
+package oauth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePrompt(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+		ok   bool
+	}{
+		{"empty", "", nil, true},
+		{"login", "login", []string{"login"}, true},
+		{"several", "login consent", []string{"login", "consent"}, true},
+		{"none alone", "none", []string{"none"}, true},
+		{"none combined", "none login", nil, false},
+		{"unknown", "create", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePrompt(tt.raw)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: prompt = %v, want %v", tt.name, got, tt.want)
+		}
+		for _, v := range tt.want {
+			if !got[v] {
+				t.Errorf("%s: prompt = %v, missing %s", tt.name, got, v)
+			}
+		}
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want int
+		ok   bool
+	}{
+		{"", -1, true},
+		{"0", 0, true},
+		{"3600", 3600, true},
+		{"-1", 0, false},
+		{"1h", 0, false},
+		{"1.5", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMaxAge(tt.raw)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseMaxAge(%q) = %d, %v, want %d", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestAuthenticatedWithin(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name            string
+		authenticatedAt time.Time
+		maxAge          int
+		want            bool
+	}{
+		{"no max_age", now.Add(-24 * time.Hour), -1, true},
+		{"recent", now.Add(-time.Minute), 300, true},
+		{"too old", now.Add(-10 * time.Minute), 300, false},
+		{"max_age 0", now.Add(-time.Minute), 0, false},
+		{"never logged in", time.Time{}, 300, false},
+	}
+
+	for _, tt := range tests {
+		if got := authenticatedWithin(tt.authenticatedAt, tt.maxAge); got != tt.want {
+			t.Errorf("%s: authenticatedWithin = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// Assertions valid for longer than this are rejected, which also
+	// bounds how long their jti must be remembered.
+	maxAssertionLifetime = time.Hour
+)
+
+var hmacMethods = []string{"HS256", "HS384", "HS512"}
+
+// clientAssertion returns the client_assertion of r, if any.
+func clientAssertion(r *http.Request) (string, error) {
+	assertion := r.PostForm.Get("client_assertion")
+	if assertion == "" {
+		return "", nil
+	}
+	if r.PostForm.Get("client_assertion_type") != clientAssertionType {
+		return "", errors.New("unsupported client_assertion_type")
+	}
+	return assertion, nil
+}
+
+// assertionSubject returns the client the assertion claims to come
+// from. The signature is checked later, once the client's keys are
+// known.
+func assertionSubject(assertion string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, claims); err != nil {
+		return "", err
+	}
+	return claims.GetSubject()
+}
+
+// verifyAssertion checks a client assertion (RFC 7523): signature, iss
+// and sub naming the client, an audience of this server, expiry, and a
+// jti not used before.
+func (a *ClientAuthenticator) verifyAssertion(r *http.Request, clientID, assertion string, keyfunc jwt.Keyfunc, methods []string) error {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(assertion, claims, keyfunc,
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(clientID),
+		jwt.WithSubject(clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return err
+	}
+
+	if !a.acceptableAudience(claims, r) {
+		return errors.New("wrong audience")
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || time.Until(exp.Time) > maxAssertionLifetime {
+		return errors.New("assertion lifetime too long")
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return errors.New("missing jti")
+	}
+
+	res, err := a.DB.ExecContext(r.Context(),
+		`INSERT INTO client_assertion_jtis (client_id, jti, expires_at)
+		 VALUES ($1,$2,$3)
+		 ON CONFLICT DO NOTHING`,
+		clientID, jti, exp.Time,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("assertion replayed")
+	}
+
+	return nil
+}
+
+// acceptableAudience accepts the issuer or the URL of the endpoint the
+// assertion was sent to.
+func (a *ClientAuthenticator) acceptableAudience(claims jwt.MapClaims, r *http.Request) bool {
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+
+	for _, v := range aud {
+		if v == a.Issuer || v == a.Issuer+r.URL.Path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package oauth
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+// Token endpoint client authentication methods.
+const (
+	AuthMethodNone          = "none"
+	AuthMethodTLSClient     = "tls_client_auth"
+	AuthMethodSelfSignedTLS = "self_signed_tls_client_auth"
+	AuthMethodPrivateKeyJWT = "private_key_jwt"
+	AuthMethodSecretJWT     = "client_secret_jwt"
+)
+
+var errInvalidClient = errors.New("invalid_client")
+
+// ClientAuthenticator authenticates clients calling the token and
+// introspection endpoints.
+type ClientAuthenticator struct {
+	DB *sql.DB
+	// Issuer is accepted as the audience of client assertions, besides
+	// the URL of the endpoint they are sent to.
+	Issuer string
+	// ClientCAs verifies certificates presented for tls_client_auth.
+	ClientCAs *x509.CertPool
+	// HTTPClient fetches key sets from jwks_uris.
+	HTTPClient *http.Client
+
+	jwksMu    sync.Mutex
+	jwksCache map[string]cachedKeySet
+}
+
+// Client is an authenticated client.
+type Client struct {
+	ID         string
+	AuthMethod string
+	// CertThumbprint is the x5t#S256 of the TLS client certificate, if
+	// one was presented. Access tokens are bound to it.
+	CertThumbprint string
+}
+
+// Authenticate identifies the client from the client_id parameter (or
+// the subject of a client assertion) and checks the proof required by
+// its registered authentication method. r's form must already be
+// parsed.
+func (a *ClientAuthenticator) Authenticate(r *http.Request) (*Client, error) {
+	assertion, err := clientAssertion(r)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if assertion != "" {
+		subject, err := assertionSubject(assertion)
+		if err != nil || (clientID != "" && clientID != subject) {
+			return nil, errInvalidClient
+		}
+		clientID = subject
+	}
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+
+	var (
+		method    string
+		subjectDN sql.NullString
+		sanDNS    sql.NullString
+		secret    sql.NullString
+	)
+	err = a.DB.QueryRowContext(r.Context(),
+		`SELECT token_endpoint_auth_method, tls_client_auth_subject_dn, tls_client_auth_san_dns, client_secret
+		 FROM oauth_clients WHERE client_id=$1`,
+		clientID,
+	).Scan(&method, &subjectDN, &sanDNS, &secret)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+
+	// Only the JWT methods take an assertion, and they require one.
+	jwtMethod := method == AuthMethodPrivateKeyJWT || method == AuthMethodSecretJWT
+	if jwtMethod != (assertion != "") {
+		return nil, errInvalidClient
+	}
+
+	client := &Client{ID: clientID, AuthMethod: method}
+
+	cert := peerCertificate(r)
+	if cert != nil {
+		client.CertThumbprint = jwtutil.CertThumbprint(cert)
+	}
+
+	switch method {
+	case AuthMethodNone:
+		return client, nil
+
+	case AuthMethodTLSClient:
+		if cert == nil || !a.verifyChain(r) {
+			return nil, errInvalidClient
+		}
+		if !matchesSubject(cert, subjectDN.String, sanDNS.String) {
+			return nil, errInvalidClient
+		}
+		return client, nil
+
+	case AuthMethodSelfSignedTLS:
+		if cert == nil {
+			return nil, errInvalidClient
+		}
+		src, err := loadClientKeys(r.Context(), a.DB, clientID)
+		if err != nil {
+			return nil, errInvalidClient
+		}
+		keys, err := a.keySet(r.Context(), src, false)
+		if err != nil || !jwksContainsCert(keys, cert) {
+			return nil, errInvalidClient
+		}
+		return client, nil
+
+	case AuthMethodPrivateKeyJWT:
+		src, err := loadClientKeys(r.Context(), a.DB, clientID)
+		if err != nil {
+			return nil, errInvalidClient
+		}
+		if err := a.verifyAssertion(r, clientID, assertion, a.keyfunc(r.Context(), src), jwtutil.SigningMethods); err != nil {
+			return nil, errInvalidClient
+		}
+		return client, nil
+
+	case AuthMethodSecretJWT:
+		if !secret.Valid || secret.String == "" {
+			return nil, errInvalidClient
+		}
+		keyfunc := func(*jwt.Token) (interface{}, error) { return []byte(secret.String), nil }
+		if err := a.verifyAssertion(r, clientID, assertion, keyfunc, hmacMethods); err != nil {
+			return nil, errInvalidClient
+		}
+		return client, nil
+	}
+
+	return nil, errInvalidClient
+}
+
+// peerCertificate returns the leaf certificate the client presented
+// during the TLS handshake.
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+func (a *ClientAuthenticator) verifyChain(r *http.Request) bool {
+	if a.ClientCAs == nil {
+		return false
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := r.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         a.ClientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// matchesSubject checks the certificate against the registered subject
+// DN or DNS SAN. Exactly one of them is expected to be registered.
+func matchesSubject(cert *x509.Certificate, subjectDN, sanDNS string) bool {
+	switch {
+	case subjectDN != "":
+		return cert.Subject.String() == subjectDN
+	case sanDNS != "":
+		for _, name := range cert.DNSNames {
+			if name == sanDNS {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwksContainsCert reports whether one of the keys in the JWK Set lists
+// cert as its first x5c entry.
+func jwksContainsCert(set *jwtutil.JWKSet, cert *x509.Certificate) bool {
+	for _, k := range set.Keys {
+		if len(k.X5c) == 0 {
+			continue
+		}
+		der, err := base64.StdEncoding.DecodeString(k.X5c[0])
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(der, cert.Raw) == 1 {
+			return true
+		}
+	}
+	return false
+}
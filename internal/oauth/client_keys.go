@@ -0,0 +1,185 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+var errNoKeys = errors.New("no registered keys")
+
+// Key sets fetched from a client's jwks_uri are reused for
+// jwksCacheTTL. A token naming a kid the cached set lacks triggers a
+// refetch, at most once per jwksMinRefetch, so rotated keys are picked
+// up without letting callers hammer the URI.
+const (
+	jwksCacheTTL     = 5 * time.Minute
+	jwksMinRefetch   = 30 * time.Second
+	maxCachedKeySets = 1024
+	maxJWKSDocument  = 256 << 10
+)
+
+type cachedKeySet struct {
+	set     *jwtutil.JWKSet
+	fetched time.Time
+}
+
+// keySource is where a client's or trusted issuer's keys come from: a
+// stored JWK Set, or the URI one is published at.
+type keySource struct {
+	raw     []byte
+	jwksURI sql.NullString
+}
+
+// loadClientKeys returns where the keys registered for clientID are
+// found, either oauth_clients.jwks or oauth_clients.jwks_uri.
+func loadClientKeys(ctx context.Context, db *sql.DB, clientID string) (*keySource, error) {
+	var src keySource
+	err := db.QueryRowContext(ctx,
+		"SELECT jwks, jwks_uri FROM oauth_clients WHERE client_id=$1",
+		clientID,
+	).Scan(&src.raw, &src.jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	return &src, nil
+}
+
+// keySet decodes the stored JWK Set of src, or fetches the one at its
+// jwksURI when none is stored. refresh bypasses a cached copy that is
+// older than jwksMinRefetch.
+func (a *ClientAuthenticator) keySet(ctx context.Context, src *keySource, refresh bool) (*jwtutil.JWKSet, error) {
+	if src.raw != nil {
+		var set jwtutil.JWKSet
+		if err := json.Unmarshal(src.raw, &set); err != nil {
+			return nil, err
+		}
+		return &set, nil
+	}
+
+	if src.jwksURI.Valid && src.jwksURI.String != "" {
+		return a.fetchKeySet(ctx, src.jwksURI.String, refresh)
+	}
+
+	return nil, errNoKeys
+}
+
+// fetchKeySet downloads the JWK Set at uri, caching it for
+// jwksCacheTTL.
+func (a *ClientAuthenticator) fetchKeySet(ctx context.Context, uri string, refresh bool) (*jwtutil.JWKSet, error) {
+	a.jwksMu.Lock()
+	cached, ok := a.jwksCache[uri]
+	a.jwksMu.Unlock()
+	if ok {
+		age := time.Since(cached.fetched)
+		if age < jwksMinRefetch || (!refresh && age < jwksCacheTTL) {
+			return cached.set, nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+
+	client := a.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks_uri returned status %d", resp.StatusCode)
+	}
+
+	var set jwtutil.JWKSet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSDocument)).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	a.jwksMu.Lock()
+	defer a.jwksMu.Unlock()
+	if a.jwksCache == nil {
+		a.jwksCache = make(map[string]cachedKeySet)
+	}
+	if len(a.jwksCache) >= maxCachedKeySets {
+		for u, c := range a.jwksCache {
+			if time.Since(c.fetched) >= jwksCacheTTL {
+				delete(a.jwksCache, u)
+			}
+		}
+	}
+	if len(a.jwksCache) < maxCachedKeySets {
+		a.jwksCache[uri] = cachedKeySet{set: &set, fetched: time.Now()}
+	}
+
+	return &set, nil
+}
+
+// keyfunc verifies JWTs signed with one of src's keys. When the token
+// names a kid that is not in a fetched set, the set is fetched again
+// once in case the keys were rotated.
+func (a *ClientAuthenticator) keyfunc(ctx context.Context, src *keySource) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		set, err := a.keySet(ctx, src, false)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := clientKeyfunc(set)(t)
+		if err == nil || src.raw != nil {
+			return key, err
+		}
+		if kid, _ := t.Header["kid"].(string); kid == "" {
+			return nil, err
+		}
+
+		set, err = a.keySet(ctx, src, true)
+		if err != nil {
+			return nil, err
+		}
+		return clientKeyfunc(set)(t)
+	}
+}
+
+// clientKeyfunc verifies JWTs signed by a client or trusted issuer with
+// one of the signing keys in set: the one named by the kid header, or
+// any of them if there is no kid.
+func clientKeyfunc(set *jwtutil.JWKSet) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		var keys []jwt.VerificationKey
+		for i := range set.Keys {
+			k := &set.Keys[i]
+			if k.Use == "enc" || (kid != "" && k.Kid != kid) {
+				continue
+			}
+			pub, err := k.PublicKey()
+			if err != nil {
+				continue
+			}
+			keys = append(keys, pub)
+		}
+
+		if len(keys) == 0 {
+			return nil, errors.New("no matching client key")
+		}
+		return jwt.VerificationKeySet{Keys: keys}, nil
+	}
+}
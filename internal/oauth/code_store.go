@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,11 @@ type AuthCode struct {
 	UserID        int
 	CodeChallenge string
 	ExpiresAt     time.Time
+	AuthMethods   []string
+	AuthTime      time.Time
+	SID           string
+	// Resources are the resource indicators given at /authorize.
+	Resources []string
 }
 
 var ErrInvalidCode = errors.New("invalid or expired authorization code")
@@ -24,9 +30,10 @@ func ConsumeAuthCode(
 ) (*AuthCode, error) {
 
 	var ac AuthCode
+	var authMethods, resources string
 
 	err := tx.QueryRowContext(ctx, `
-		SELECT code, client_id, user_id, code_challenge, expires_at
+		SELECT code, client_id, user_id, code_challenge, expires_at, auth_methods, auth_time, sid, resources
 		FROM authorization_codes
 		WHERE code = $1
 	`, code).Scan(
@@ -35,12 +42,19 @@ func ConsumeAuthCode(
 		&ac.UserID,
 		&ac.CodeChallenge,
 		&ac.ExpiresAt,
+		&authMethods,
+		&ac.AuthTime,
+		&ac.SID,
+		&resources,
 	)
 
 	if err != nil {
 		return nil, ErrInvalidCode
 	}
 
+	ac.AuthMethods = strings.Fields(authMethods)
+	ac.Resources = strings.Fields(resources)
+
 	if time.Now().After(ac.ExpiresAt) {
 		return nil, ErrInvalidCode
 	}
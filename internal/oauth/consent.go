@@ -0,0 +1,70 @@
+package oauth
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
+)
+
+// hasConsent reports whether userID has allowed clientID before.
+func (h *AuthorizeHandler) hasConsent(r *http.Request, userID int, clientID string) (bool, error) {
+	var consented bool
+	err := h.DB.QueryRowContext(r.Context(),
+		"SELECT EXISTS (SELECT 1 FROM user_consents WHERE user_id=$1 AND client_id=$2)",
+		userID, clientID,
+	).Scan(&consented)
+	return consented, err
+}
+
+// grantConsent records that userID allowed clientID.
+func (h *AuthorizeHandler) grantConsent(r *http.Request, userID int, clientID string) error {
+	_, err := h.DB.ExecContext(r.Context(),
+		`INSERT INTO user_consents (user_id, client_id) VALUES ($1,$2)
+		 ON CONFLICT (user_id, client_id) DO UPDATE SET granted_at=now()`,
+		userID, clientID,
+	)
+	return err
+}
+
+// askConsent shows the consent page. Its form posts the decision back
+// to /authorize with the request, minus prompt=consent, which the
+// decision satisfies.
+func (h *AuthorizeHandler) askConsent(w http.ResponseWriter, r *http.Request, req *authorizationRequest, prompt map[string]bool) {
+	q := url.Values{}
+	for k, v := range req.params {
+		q[k] = v
+	}
+	q.Del("request")
+	q.Del("request_uri")
+	q.Del("prompt")
+
+	var rest []string
+	for v := range prompt {
+		if v != "consent" {
+			rest = append(rest, v)
+		}
+	}
+	if len(rest) > 0 {
+		q.Set("prompt", strings.Join(rest, " "))
+	}
+
+	q, err := h.resumable(r, req, q)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("web/templates/consent.html")
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, map[string]interface{}{
+		"ClientID":  q.Get("client_id"),
+		"Action":    r.URL.Path + "?" + q.Encode(),
+		"CSRFToken": middleware.IssueCSRFToken(w),
+	})
+}
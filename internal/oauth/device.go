@@ -0,0 +1,256 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/auth"
+	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
+)
+
+const (
+	deviceGrantType    = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceCodeLifetime = 10 * time.Minute
+	// devicePollInterval is the minimum number of seconds between token
+	// requests; each slow_down adds another 5 (RFC 8628 section 3.5).
+	devicePollInterval = 5
+
+	// User codes avoid vowels and look-alike characters so they are easy
+	// to read off one screen and type on another.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+
+	// A user may enter at most deviceAttemptLimit wrong user codes per
+	// deviceAttemptWindow (RFC 8628 section 5.1).
+	deviceAttemptLimit  = 10
+	deviceAttemptWindow = 15 * time.Minute
+)
+
+const invalidUserCode = "That code is invalid or has expired."
+
+// DeviceHandler implements the device authorization endpoint and the
+// verification page of the device authorization grant (RFC 8628).
+type DeviceHandler struct {
+	DB      *sql.DB
+	Clients *ClientAuthenticator
+	// Issuer is the base of the verification_uri sent to devices.
+	Issuer string
+}
+
+// Authorize starts a device authorization: the device shows the
+// user_code and polls /token with the device_code.
+func (h *DeviceHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.Clients.Authenticate(r)
+	if err != nil {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	deviceCode := randomCode()
+	userCode := newUserCode()
+
+	_, err = h.DB.ExecContext(r.Context(),
+		`INSERT INTO device_codes (device_code, user_code, client_id, poll_interval, expires_at)
+		 VALUES ($1,$2,$3,$4,$5)`,
+		deviceCode, userCode, client.ID, devicePollInterval, time.Now().Add(deviceCodeLifetime),
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	verificationURI := h.Issuer + "/device"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 formatUserCode(userCode),
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?" + url.Values{"user_code": {formatUserCode(userCode)}}.Encode(),
+		"expires_in":                int(deviceCodeLifetime.Seconds()),
+		"interval":                  devicePollInterval,
+	})
+}
+
+// Page lets the signed-in user enter a user code and approve or deny
+// the device (CSRF protected).
+func (h *DeviceHandler) Page(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	view := map[string]interface{}{}
+
+	if r.Method == http.MethodGet {
+		view["UserCode"] = r.URL.Query().Get("user_code")
+		h.render(w, view)
+		return
+	}
+
+	session, err := auth.CurrentSession(h.DB, r)
+	if err != nil {
+		auth.RedirectToLogin(h.DB, w, r)
+		return
+	}
+
+	if !session.EmailVerified {
+		http.Error(w, "email address not verified", http.StatusForbidden)
+		return
+	}
+
+	userCode := normalizeUserCode(r.PostFormValue("user_code"))
+	view["UserCode"] = formatUserCode(userCode)
+
+	var failed int
+	err = h.DB.QueryRowContext(r.Context(),
+		`SELECT count(*) FROM device_code_attempts
+		 WHERE user_id=$1 AND attempted_at > $2`,
+		session.UserID, time.Now().Add(-deviceAttemptWindow),
+	).Scan(&failed)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if failed >= deviceAttemptLimit {
+		log.Printf("device user code attempts rate limited user_id=%d", session.UserID)
+		view["Error"] = "Too many attempts. Try again later."
+		h.render(w, view)
+		return
+	}
+
+	var res sql.Result
+	switch r.PostFormValue("action") {
+	case "":
+		// Show which client is asking before the user decides.
+		var clientID string
+		err := h.DB.QueryRowContext(r.Context(),
+			`SELECT client_id FROM device_codes
+			 WHERE user_code=$1 AND status='pending' AND expires_at > now()`,
+			userCode,
+		).Scan(&clientID)
+		if err == sql.ErrNoRows {
+			if !h.recordFailedAttempt(w, r, session.UserID) {
+				return
+			}
+			view["Error"] = invalidUserCode
+		} else if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		view["ClientID"] = clientID
+		h.render(w, view)
+		return
+
+	case "approve":
+		res, err = h.DB.ExecContext(r.Context(),
+			`UPDATE device_codes
+			 SET status='approved', user_id=$2, auth_methods=$3, auth_time=$4, sid=$5
+			 WHERE user_code=$1 AND status='pending' AND expires_at > now()`,
+			userCode, session.UserID, session.AuthMethods, session.AuthenticatedAt, session.SID,
+		)
+		view["Done"] = "Device connected."
+
+	case "deny":
+		res, err = h.DB.ExecContext(r.Context(),
+			`UPDATE device_codes SET status='denied'
+			 WHERE user_code=$1 AND status='pending' AND expires_at > now()`,
+			userCode,
+		)
+		view["Done"] = "Access denied."
+
+	default:
+		http.Error(w, "invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		log.Println("device authorization update failed:", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if !h.recordFailedAttempt(w, r, session.UserID) {
+			return
+		}
+		delete(view, "Done")
+		view["Error"] = invalidUserCode
+	}
+
+	h.render(w, view)
+}
+
+// recordFailedAttempt counts a wrong user code against userID. It
+// reports false after writing an error response.
+func (h *DeviceHandler) recordFailedAttempt(w http.ResponseWriter, r *http.Request, userID int) bool {
+	_, err := h.DB.ExecContext(r.Context(),
+		"INSERT INTO device_code_attempts (user_id) VALUES ($1)",
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (h *DeviceHandler) render(w http.ResponseWriter, view map[string]interface{}) {
+	tmpl, err := template.ParseFiles("web/templates/device.html")
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	view["CSRFToken"] = middleware.IssueCSRFToken(w)
+	tmpl.Execute(w, view)
+}
+
+// newUserCode returns a random user code in its stored form, without
+// the separator.
+func newUserCode() string {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	b := make([]byte, userCodeLength)
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, max)
+		b[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+// normalizeUserCode undoes formatting and case changes a user may have
+// made while typing the code.
+func normalizeUserCode(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(s) {
+		if strings.ContainsRune(userCodeAlphabet, c) {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// formatUserCode renders a stored user code as XXXX-XXXX.
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
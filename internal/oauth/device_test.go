@@ -0,0 +1,34 @@
+package oauth
+
+import "testing"
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"BCDF-GHJK", "BCDFGHJK"},
+		{"bcdf-ghjk", "BCDFGHJK"},
+		{" bcdf ghjk ", "BCDFGHJK"},
+		{"BCDFGHJK", "BCDFGHJK"},
+		{"BCDF–GHJK", "BCDFGHJK"},
+		{"AEIOU", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeUserCode(tt.in); got != tt.want {
+			t.Errorf("normalizeUserCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewUserCodeRoundTrip(t *testing.T) {
+	code := newUserCode()
+	if len(code) != userCodeLength {
+		t.Fatalf("user code %q has length %d", code, len(code))
+	}
+	if got := normalizeUserCode(formatUserCode(code)); got != code {
+		t.Errorf("normalizeUserCode(formatUserCode(%q)) = %q", code, got)
+	}
+}
@@ -0,0 +1,68 @@
+package oauth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
+)
+
+// IntrospectHandler implements token introspection (RFC 7662) for
+// Sentinel access tokens.
+type IntrospectHandler struct {
+	DB         *sql.DB
+	KeyManager *jwtutil.KeyManager
+	Issuer     string
+	Clients    *ClientAuthenticator
+}
+
+func (h *IntrospectHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	// Public clients cannot prove who is asking, so anyone could use
+	// them to probe tokens.
+	client, err := h.Clients.Authenticate(r)
+	if err != nil || client.AuthMethod == AuthMethodNone {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	claims, err := middleware.ParseAccessToken(r.Context(), h.DB, h.KeyManager, h.Issuer, r.PostForm.Get("token"))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+		return
+	}
+
+	// The caller is the resource server, not the token holder, so the
+	// certificate on this request says nothing about the binding. cnf is
+	// returned for the resource server to check against its own client
+	// (RFC 8705 section 3.2).
+	resp := map[string]interface{}{
+		"active":     true,
+		"token_type": "Bearer",
+	}
+	if cnf, ok := claims["cnf"].(map[string]interface{}); ok && cnf["jkt"] != nil {
+		resp["token_type"] = "DPoP"
+	}
+	for _, k := range []string{"scope", "sub", "aud", "iss", "exp", "iat", "jti", "cnf", "act"} {
+		if v, ok := claims[k]; ok {
+			resp[k] = v
+		}
+	}
+	resp["client_id"] = tokenClientID(claims)
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,196 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+const jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+var errUnknownSubject = errors.New("subject not mapped to a user")
+
+// trustedIssuer is an external issuer whose JWTs are accepted by the
+// jwt-bearer grant.
+type trustedIssuer struct {
+	issuer         string
+	keys           *keySource
+	subjectMapping string
+	// allowedClients may present the issuer's JWTs; tokens they get
+	// carry at most scopes.
+	allowedClients []string
+	scopes         []string
+}
+
+// loadTrustedIssuer returns the registered issuer iss.
+func loadTrustedIssuer(ctx context.Context, db *sql.DB, iss string) (*trustedIssuer, error) {
+	var allowedClients, scopes string
+	t := &trustedIssuer{issuer: iss, keys: &keySource{}}
+	err := db.QueryRowContext(ctx,
+		`SELECT jwks, jwks_uri, subject_mapping, allowed_clients, scopes
+		 FROM trusted_issuers WHERE issuer=$1`,
+		iss,
+	).Scan(&t.keys.raw, &t.keys.jwksURI, &t.subjectMapping, &allowedClients, &scopes)
+	if err != nil {
+		return nil, err
+	}
+	t.allowedClients = strings.Fields(allowedClients)
+	t.scopes = strings.Fields(scopes)
+	return t, nil
+}
+
+// mapSubject finds the local user for the issuer's subject.
+func (t *trustedIssuer) mapSubject(ctx context.Context, db *sql.DB, subject string) (int, error) {
+	var row *sql.Row
+	switch t.subjectMapping {
+	case "explicit":
+		row = db.QueryRowContext(ctx,
+			"SELECT user_id FROM trusted_issuer_subjects WHERE issuer=$1 AND subject=$2",
+			t.issuer, subject,
+		)
+	case "username":
+		row = db.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1", subject)
+	case "email":
+		// Unverified addresses could have been claimed by anyone.
+		row = db.QueryRowContext(ctx, "SELECT id FROM users WHERE email=$1 AND email_verified", subject)
+	default:
+		return 0, errUnknownSubject
+	}
+
+	var userID int
+	err := row.Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errUnknownSubject
+	}
+	return userID, err
+}
+
+// handleJWTBearer implements the JWT bearer grant (RFC 7523): a JWT
+// from a trusted issuer, signed with one of its keys and addressed to
+// this server, is exchanged for an access token for the user its
+// subject maps to. No refresh token is issued.
+func (h *TokenHandler) handleJWTBearer(w http.ResponseWriter, r *http.Request, client *Client, jkt string) {
+	assertion := r.FormValue("assertion")
+	if assertion == "" {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	ctx := r.Context()
+
+	opts, err := h.resourceOptions(r, nil)
+	if errors.Is(err, errInvalidTarget) {
+		tokenError(w, "invalid_target")
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	unverified := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, unverified); err != nil {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	iss, _ := unverified.GetIssuer()
+
+	issuer, err := loadTrustedIssuer(ctx, h.DB, iss)
+	if err != nil {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	if !slices.Contains(issuer.allowedClients, client.ID) {
+		tokenError(w, "unauthorized_client")
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(assertion, claims, h.Clients.keyfunc(ctx, issuer.keys),
+		jwt.WithValidMethods(jwtutil.SigningMethods),
+		jwt.WithIssuer(iss),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !h.Clients.acceptableAudience(claims, r) {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || time.Until(exp.Time) > maxAssertionLifetime {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	subject, _ := claims.GetSubject()
+	jti, _ := claims["jti"].(string)
+	if subject == "" || jti == "" {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	res, err := h.DB.ExecContext(ctx,
+		`INSERT INTO jwt_bearer_jtis (issuer, jti, expires_at)
+		 VALUES ($1,$2,$3)
+		 ON CONFLICT DO NOTHING`,
+		iss, jti, exp.Time,
+	)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	userID, err := issuer.mapSubject(ctx, h.DB, subject)
+	if errors.Is(err, errUnknownSubject) {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	opts = append(opts,
+		jwtutil.WithAllowedScopes(issuer.scopes),
+		jwtutil.WithCertThumbprint(client.CertThumbprint),
+		jwtutil.WithDPoPKey(jkt),
+	)
+	accessToken, err := h.Signer.MintAccessToken(ctx, userID, client.ID, opts...)
+	if err != nil {
+		http.Error(w, "token signing failed", http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Record(r, audit.Event{
+		Type:     audit.TokenMinted,
+		UserID:   audit.User(userID),
+		ClientID: client.ID,
+		Details: map[string]interface{}{
+			"grant_type": jwtBearerGrantType,
+			"issuer":     iss,
+			"subject":    subject,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   tokenType(jkt),
+		"expires_in":   900,
+	})
+}
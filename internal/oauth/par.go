@@ -0,0 +1,160 @@
+package oauth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+	parLifetime      = 60 * time.Second
+	// parLoginWindow is how long a pushed request stays usable once the
+	// user has been sent to log in.
+	parLoginWindow = 10 * time.Minute
+)
+
+// client authentication parameters are not part of the authorization
+// request and are not stored.
+var clientAuthParams = []string{"client_secret", "client_assertion", "client_assertion_type"}
+
+// PARHandler implements the pushed authorization request endpoint
+// (RFC 9126).
+type PARHandler struct {
+	DB      *sql.DB
+	Clients *ClientAuthenticator
+	// Issuer is the audience request objects must be addressed to.
+	Issuer string
+}
+
+func (h *PARHandler) Push(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.Clients.Authenticate(r)
+	if err != nil {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	params := url.Values{}
+	for k, v := range r.PostForm {
+		params[k] = v
+	}
+	for _, k := range clientAuthParams {
+		params.Del(k)
+	}
+	// Clients using assertions may leave client_id implicit.
+	params.Set("client_id", client.ID)
+
+	if params.Has("request_uri") {
+		http.Error(w, "request_uri is not allowed here", http.StatusBadRequest)
+		return
+	}
+
+	if obj := params.Get("request"); obj != "" {
+		params, err = h.Clients.verifyRequestObject(r.Context(), h.Issuer, client.ID, obj, params)
+		if err != nil {
+			http.Error(w, "invalid request object", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var dbRedirect string
+	err = h.DB.QueryRowContext(r.Context(),
+		"SELECT redirect_uri FROM oauth_clients WHERE client_id=$1",
+		client.ID,
+	).Scan(&dbRedirect)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if params.Get("redirect_uri") != dbRedirect {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if err := ValidatePKCE(params.Get("code_challenge"), params.Get("code_challenge_method")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestURI, err := storePushedRequest(r.Context(), h.DB, client.ID, params, parLifetime)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"request_uri": requestURI,
+		"expires_in":  int(parLifetime.Seconds()),
+	})
+}
+
+// storePushedRequest saves params for clientID and returns the
+// request_uri referring to them.
+func storePushedRequest(ctx context.Context, db *sql.DB, clientID string, params url.Values, lifetime time.Duration) (string, error) {
+	requestURI := requestURIPrefix + randomCode()
+
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO pushed_authorization_requests (request_uri, client_id, params, expires_at)
+		 VALUES ($1,$2,$3,$4)`,
+		requestURI, clientID, params.Encode(), time.Now().Add(lifetime),
+	)
+	return requestURI, err
+}
+
+// loadPushedRequest returns the parameters pushed by clientID under
+// requestURI, if it has not expired or been used.
+func (h *AuthorizeHandler) loadPushedRequest(r *http.Request, clientID, requestURI string) (url.Values, error) {
+	var raw string
+	err := h.DB.QueryRowContext(r.Context(),
+		`SELECT params FROM pushed_authorization_requests
+		 WHERE request_uri=$1 AND client_id=$2 AND expires_at > now()`,
+		requestURI, clientID,
+	).Scan(&raw)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(raw)
+}
+
+// holdPushedRequest replaces the stored parameters of a pushed request
+// and keeps it alive while the user logs in.
+func (h *AuthorizeHandler) holdPushedRequest(r *http.Request, requestURI string, params url.Values) error {
+	_, err := h.DB.ExecContext(r.Context(),
+		`UPDATE pushed_authorization_requests
+		 SET params=$1, expires_at=GREATEST(expires_at, $2)
+		 WHERE request_uri=$3`,
+		params.Encode(), time.Now().Add(parLoginWindow), requestURI,
+	)
+	return err
+}
+
+// consumePushedRequest deletes a pushed request so it cannot be used
+// for a second authorization code. It reports whether it was still
+// there.
+func (h *AuthorizeHandler) consumePushedRequest(r *http.Request, requestURI string) (bool, error) {
+	res, err := h.DB.ExecContext(r.Context(),
+		"DELETE FROM pushed_authorization_requests WHERE request_uri=$1 AND expires_at > now()",
+		requestURI,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
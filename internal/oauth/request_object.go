@@ -0,0 +1,228 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+const maxRequestObjectSize = 64 << 10
+
+// Claims of a request object that describe the JWT itself rather than
+// the authorization request.
+var requestObjectJWTClaims = map[string]bool{
+	"iss": true, "aud": true, "exp": true, "iat": true, "nbf": true, "jti": true,
+}
+
+// authorizationRequest is an authorization request after resolving
+// pushed requests (PAR) and request objects (JAR).
+type authorizationRequest struct {
+	params url.Values
+	// pushedURI is the /par request_uri the request was loaded from.
+	pushedURI string
+	// signed is set when the parameters came from a request object.
+	signed bool
+}
+
+// resolveRequest collects the parameters of an authorization request
+// from the query string, a pushed request, and a request object passed
+// by value (request) or by reference (request_uri).
+func (h *AuthorizeHandler) resolveRequest(r *http.Request, clientID string) (*authorizationRequest, error) {
+	req := &authorizationRequest{params: r.URL.Query()}
+
+	requestURI := req.params.Get("request_uri")
+	requestObject := req.params.Get("request")
+	if requestURI != "" && requestObject != "" {
+		return nil, errors.New("request and request_uri are mutually exclusive")
+	}
+
+	switch {
+	case strings.HasPrefix(requestURI, requestURIPrefix):
+		pushed, err := h.loadPushedRequest(r, clientID, requestURI)
+		if err != nil {
+			return nil, errors.New("invalid request_uri")
+		}
+		// Request objects sent to /par were verified there.
+		req.params = pushed
+		req.pushedURI = requestURI
+
+	case requestURI != "":
+		obj, err := h.fetchRequestObject(r, clientID, requestURI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request_uri: %w", err)
+		}
+		requestObject = obj
+	}
+
+	if requestObject != "" {
+		params, err := h.Clients.verifyRequestObject(r.Context(), h.Issuer, clientID, requestObject, req.params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request object: %w", err)
+		}
+		req.params = params
+		req.signed = true
+	}
+
+	return req, nil
+}
+
+// fetchRequestObject downloads a request object from one of the
+// client's registered request_uris.
+func (h *AuthorizeHandler) fetchRequestObject(r *http.Request, clientID, requestURI string) (string, error) {
+	var registered string
+	err := h.DB.QueryRowContext(r.Context(),
+		"SELECT COALESCE(request_uris, '') FROM oauth_clients WHERE client_id=$1",
+		clientID,
+	).Scan(&registered)
+	if err != nil {
+		return "", err
+	}
+
+	allowed := false
+	for _, u := range strings.Fields(registered) {
+		if u == requestURI {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", errors.New("request_uri not registered")
+	}
+
+	client := h.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	fetch, err := http.NewRequestWithContext(r.Context(), http.MethodGet, requestURI, nil)
+	if err != nil {
+		return "", err
+	}
+	fetch.Header.Set("Accept", "application/oauth-authz-req+jwt")
+
+	resp, err := client.Do(fetch)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRequestObjectSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// verifyRequestObject checks the request object's signature against the
+// client's JWKS, its expiry and that its jti was not used before, and
+// merges its claims into params. Parameters from the request object
+// take precedence.
+func (a *ClientAuthenticator) verifyRequestObject(ctx context.Context, issuer, clientID, requestObject string, params url.Values) (url.Values, error) {
+	keys, err := loadClientKeys(ctx, a.DB, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(requestObject, claims, a.keyfunc(ctx, keys),
+		jwt.WithValidMethods(jwtutil.SigningMethods),
+		jwt.WithIssuer(clientID),
+		jwt.WithAudience(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, ok := claims["client_id"].(string); ok && id != clientID {
+		return nil, errors.New("client_id mismatch")
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || time.Until(exp.Time) > maxAssertionLifetime {
+		return nil, errors.New("request object lifetime too long")
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, errors.New("missing jti")
+	}
+
+	// Requests interrupted by login or consent resume from a pushed
+	// request, so a request object is only ever verified once.
+	res, err := a.DB.ExecContext(ctx,
+		`INSERT INTO request_object_jtis (client_id, jti, expires_at)
+		 VALUES ($1,$2,$3)
+		 ON CONFLICT DO NOTHING`,
+		clientID, jti, exp.Time,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errors.New("request object replayed")
+	}
+
+	merged := url.Values{}
+	for k, v := range params {
+		merged[k] = v
+	}
+	merged.Del("request")
+	merged.Del("request_uri")
+
+	for k, v := range claims {
+		if requestObjectJWTClaims[k] {
+			continue
+		}
+		// Several resource indicators (RFC 8707) come as an array.
+		if list, ok := v.([]interface{}); ok && k == "resource" {
+			merged.Del(k)
+			for _, item := range list {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("claim %s: not a string", k)
+				}
+				merged.Add(k, s)
+			}
+			continue
+		}
+		s, err := paramValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("claim %s: %w", k, err)
+		}
+		merged.Set(k, s)
+	}
+
+	return merged, nil
+}
+
+// paramValue renders a request object claim as a query parameter value.
+// Objects such as the claims parameter are passed on as JSON.
+func paramValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
@@ -0,0 +1,64 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// These cases are all decided before api_resources is consulted, so
+// they run without a database.
+
+func TestCheckResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []string
+		err       error
+	}{
+		{"none", nil, nil},
+		{"relative", []string{"/api"}, errInvalidTarget},
+		{"not a URI", []string{"payments"}, errInvalidTarget},
+		{"fragment", []string{"https://api.example.com/#x"}, errInvalidTarget},
+		{"one bad of several", []string{"https://api.example.com/#x", "payments"}, errInvalidTarget},
+	}
+
+	for _, tt := range tests {
+		if err := checkResources(context.Background(), nil, tt.resources); !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestResourceOptions(t *testing.T) {
+	h := &TokenHandler{}
+
+	tests := []struct {
+		name      string
+		requested []string
+		granted   []string
+		err       error
+		options   bool
+	}{
+		{"nothing", nil, nil, nil, false},
+		{"several granted, none requested", nil, []string{"https://a.example.com", "https://b.example.com"}, nil, false},
+		{"several requested", []string{"https://a.example.com", "https://b.example.com"}, nil, errInvalidTarget, false},
+		{"not granted", []string{"https://b.example.com"}, []string{"https://a.example.com"}, errInvalidTarget, false},
+		{"invalid requested", []string{"payments"}, nil, errInvalidTarget, false},
+		{"invalid granted", nil, []string{"payments"}, errInvalidTarget, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/token", nil)
+		r.PostForm = url.Values{"resource": tt.requested}
+
+		opts, err := h.resourceOptions(r, tt.granted)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if (len(opts) > 0) != tt.options {
+			t.Errorf("%s: %d options", tt.name, len(opts))
+		}
+	}
+}
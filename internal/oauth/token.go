@@ -7,16 +7,27 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
+	"github.com/SAMurai-16/sentinel-idp/internal/dpop"
 	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+	"github.com/SAMurai-16/sentinel-idp/internal/metrics"
+	"github.com/SAMurai-16/sentinel-idp/internal/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TokenHandler struct {
-	DB     *sql.DB
-	Signer *jwtutil.Signer
+	DB      *sql.DB
+	Signer  *jwtutil.Signer
+	Clients *ClientAuthenticator
+	DPoP    *dpop.Verifier
+	Audit   *audit.Logger
 }
 
 
@@ -33,9 +44,34 @@ type TokenHandler struct {
 
 
 
-func(h *TokenHandler) handleAuthorizationCode(w http.ResponseWriter,r *http.Request){
+// tokenType is "DPoP" for access tokens bound to a DPoP key.
+func tokenType(jkt string) string {
+	if jkt != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// refreshBinding returns the DPoP key a new refresh token is bound to.
+// Only public clients get bound refresh tokens; confidential clients
+// authenticate instead (RFC 9449 section 5).
+func refreshBinding(client *Client, jkt string) sql.NullString {
+	return sql.NullString{String: jkt, Valid: jkt != "" && client.AuthMethod == AuthMethodNone}
+}
+
+// refreshCertBinding returns the client certificate a new refresh token
+// is bound to. As with refreshBinding, only public clients' tokens are
+// bound (RFC 8705 section 4).
+func refreshCertBinding(client *Client) sql.NullString {
+	return sql.NullString{
+		String: client.CertThumbprint,
+		Valid:  client.CertThumbprint != "" && client.AuthMethod == AuthMethodNone,
+	}
+}
+
+func(h *TokenHandler) handleAuthorizationCode(w http.ResponseWriter,r *http.Request, client *Client, jkt string){
 		code := r.FormValue("code")
-	clientID := r.FormValue("client_id")
+	clientID := client.ID
 	verifier := r.FormValue("code_verifier")
 
 	if code == "" || clientID == "" || verifier == "" {
@@ -43,7 +79,7 @@ func(h *TokenHandler) handleAuthorizationCode(w http.ResponseWriter,r *http.Requ
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
@@ -70,17 +106,34 @@ func(h *TokenHandler) handleAuthorizationCode(w http.ResponseWriter,r *http.Requ
 		return
 	}
 
+	opts, err := h.resourceOptions(r, authCode.Resources)
+	if errors.Is(err, errInvalidTarget) {
+		tokenError(w, "invalid_target")
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
 	//mint access token
-	accessToken, err := h.Signer.MintAccessToken(authCode.UserID, clientID)
+	opts = append(opts,
+		jwtutil.WithCertThumbprint(client.CertThumbprint),
+		jwtutil.WithDPoPKey(jkt),
+	)
+	accessToken, err := h.Signer.MintAccessToken(ctx, authCode.UserID, clientID, opts...)
 	if err != nil {
 		http.Error(w, "token signing failed", http.StatusInternalServerError)
 		return
 	}
 
 	idToken, err := h.Signer.MintIDToken(
+	ctx,
 	authCode.UserID,
 	clientID,
-	authCode.ExpiresAt,
+	authCode.AuthTime,
+	authCode.AuthMethods,
+	authCode.SID,
 	)
 	if err != nil {
 	http.Error(w, "id token signing failed", http.StatusInternalServerError)
@@ -90,19 +143,35 @@ func(h *TokenHandler) handleAuthorizationCode(w http.ResponseWriter,r *http.Requ
 	// print(idToken)
 
 
+	// Remember which clients hold tokens from this session so logout
+	// can notify them.
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO session_clients (sid, client_id)
+		SELECT sid, $2 FROM sessions WHERE sid=$1
+		ON CONFLICT DO NOTHING
+	`, authCode.SID, clientID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
 	//Create refresh token
 	rawRT, hashRT := generateRefreshToken()
 	rtID := uuid.New()
 
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO refresh_tokens
-		(id, user_id, client_id, token_hash, expires_at)
-		VALUES ($1,$2,$3,$4, now() + interval '30 days')
+		(id, user_id, client_id, token_hash, expires_at, sid, dpop_jkt, cert_thumbprint, resources)
+		VALUES ($1,$2,$3,$4, now() + interval '30 days', $5, $6, $7, $8)
 	`,
 		rtID,
 		authCode.UserID,
 		clientID,
 		hashRT,
+		authCode.SID,
+		refreshBinding(client, jkt),
+		refreshCertBinding(client),
+		strings.Join(authCode.Resources, " "),
 	)
 	if err != nil {
 		http.Error(w, "failed to store refresh token", http.StatusInternalServerError)
@@ -115,13 +184,22 @@ func(h *TokenHandler) handleAuthorizationCode(w http.ResponseWriter,r *http.Requ
 		return
 	}
 
-	
+	h.Audit.Record(r, audit.Event{
+		Type:     audit.TokenMinted,
+		UserID:   audit.User(authCode.UserID),
+		ClientID: clientID,
+		Details: map[string]interface{}{
+			"grant_type":       "authorization_code",
+			"refresh_token_id": rtID.String(),
+		},
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"access_token":  accessToken,
 		"id_token": idToken,
 		"refresh_token": rawRT,
-		"token_type":    "Bearer",
+		"token_type":    tokenType(jkt),
 		"expires_in":    900,
 	})
 }
@@ -129,12 +207,20 @@ func(h *TokenHandler) handleAuthorizationCode(w http.ResponseWriter,r *http.Requ
 
 
 
-func (h *TokenHandler) revokeRefreshFamily(tx *sql.Tx, id uuid.UUID) {
-	tx.Exec(`
+// revokeRefreshFamily revokes a refresh token and every token rotated
+// from it.
+func (h *TokenHandler) revokeRefreshFamily(ctx context.Context, tx *sql.Tx, id uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		WITH RECURSIVE family AS (
+			SELECT id FROM refresh_tokens WHERE id=$1
+			UNION
+			SELECT rt.id FROM refresh_tokens rt JOIN family f ON rt.parent_id = f.id
+		)
 		UPDATE refresh_tokens
 		SET revoked=true
-		WHERE id=$1 OR parent_id=$1
+		WHERE id IN (SELECT id FROM family)
 	`, id)
+	return err
 }
 
 
@@ -145,10 +231,10 @@ func hashRefreshToken(raw string) string {
 
 
 
-func (h *TokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+func (h *TokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request, client *Client, jkt string) {
 
 	rawRT := r.FormValue("refresh_token")
-	clientID := r.FormValue("client_id")
+	clientID := client.ID
 
 	if rawRT == "" || clientID == "" {
 		http.Error(w, "missing parameters", http.StatusBadRequest)
@@ -157,7 +243,7 @@ func (h *TokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request
 
 	hashRT := hashRefreshToken(rawRT)
 
-	ctx := context.Background()
+	ctx := r.Context()
 	tx, err := h.DB.BeginTx(ctx, nil)
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
@@ -171,10 +257,14 @@ func (h *TokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request
 		revoked   bool
 		parentID  *uuid.UUID
 		expiresAt time.Time
+		sid       sql.NullString
+		boundJKT  sql.NullString
+		boundCert sql.NullString
+		resources string
 	)
 
-	err = tx.QueryRow(`
-		SELECT id, user_id, revoked, parent_id, expires_at
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, revoked, parent_id, expires_at, sid, dpop_jkt, cert_thumbprint, resources
 		FROM refresh_tokens
 		WHERE token_hash=$1 AND client_id=$2
 	`, hashRT, clientID).Scan(
@@ -183,17 +273,61 @@ func (h *TokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request
 		&revoked,
 		&parentID,
 		&expiresAt,
+		&sid,
+		&boundJKT,
+		&boundCert,
+		&resources,
 	)
 
 
-	if err != nil || revoked || time.Now().After(expiresAt) {
-		h.revokeRefreshFamily(tx, rtID)
+	if err == nil && revoked {
+		// A rotated-out token came back: assume it leaked and kill the
+		// whole family, committing even though the request fails.
+		if err := h.revokeRefreshFamily(ctx, tx, rtID); err == nil {
+			tx.Commit()
+		}
+
+		metrics.RefreshReuseDetected.Inc()
+		h.Audit.Record(r, audit.Event{
+			Type:     audit.RefreshReuseDetected,
+			UserID:   audit.User(userID),
+			ClientID: clientID,
+			Details:  map[string]interface{}{"refresh_token_id": rtID.String()},
+		})
+
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil || time.Now().After(expiresAt) {
 		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
 		return
 	}
 
+	// A bound refresh token is only usable with a proof from its key.
+	if boundJKT.Valid && boundJKT.String != jkt {
+		tokenError(w, "invalid_dpop_proof")
+		return
+	}
+
+	// Likewise one bound to a certificate needs that certificate.
+	if boundCert.Valid && boundCert.String != client.CertThumbprint {
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	opts, err := h.resourceOptions(r, strings.Fields(resources))
+	if errors.Is(err, errInvalidTarget) {
+		tokenError(w, "invalid_target")
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
 
-	_, err = tx.Exec(
+	_, err = tx.ExecContext(ctx,
 		`UPDATE refresh_tokens SET revoked=true WHERE id=$1`,
 		rtID,
 	)
@@ -206,19 +340,23 @@ func (h *TokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request
 	newRaw, newHash := generateRefreshToken()
 	newID := uuid.New()
 
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO refresh_tokens
-		(id, user_id, client_id, token_hash, expires_at, parent_id)
-		VALUES ($1,$2,$3,$4, now() + interval '30 days', $5)
+		(id, user_id, client_id, token_hash, expires_at, parent_id, sid, dpop_jkt, cert_thumbprint, resources)
+		VALUES ($1,$2,$3,$4, now() + interval '30 days', $5, $6, $7, $8, $9)
 	`,
-		newID, userID, clientID, newHash, rtID,
+		newID, userID, clientID, newHash, rtID, sid, boundJKT, boundCert, resources,
 	)
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
 
-	accessToken, err := h.Signer.MintAccessToken(userID, clientID)
+	opts = append(opts,
+		jwtutil.WithCertThumbprint(client.CertThumbprint),
+		jwtutil.WithDPoPKey(jkt),
+	)
+	accessToken, err := h.Signer.MintAccessToken(ctx, userID, clientID, opts...)
 	if err != nil {
 		http.Error(w, "token signing failed", http.StatusInternalServerError)
 		return
@@ -229,12 +367,223 @@ func (h *TokenHandler) handleRefreshToken(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	h.Audit.Record(r, audit.Event{
+		Type:     audit.TokenMinted,
+		UserID:   audit.User(userID),
+		ClientID: clientID,
+		Details: map[string]interface{}{
+			"grant_type":       "refresh_token",
+			"refresh_token_id": newID.String(),
+		},
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"access_token":  accessToken,
 		"refresh_token": newRaw,
-		"token_type":    "Bearer",
+		"token_type":    tokenType(jkt),
+		"expires_in":    900,
+	})
+}
+
+
+
+
+
+// tokenError answers with an RFC 6749 error object, for grants whose
+// clients act on the error code (device polling, token exchange).
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (h *TokenHandler) handleDeviceCode(w http.ResponseWriter, r *http.Request, client *Client, jkt string) {
+	deviceCode := r.FormValue("device_code")
+	clientID := client.ID
+
+	if deviceCode == "" {
+		http.Error(w, "missing parameters", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var (
+		ownerID      string
+		status       string
+		userID       sql.NullInt64
+		authMethods  sql.NullString
+		authTime     sql.NullTime
+		sid          sql.NullString
+		pollInterval int
+		lastPolledAt sql.NullTime
+		expiresAt    time.Time
+	)
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT client_id, status, user_id, auth_methods, auth_time, sid, poll_interval, last_polled_at, expires_at
+		FROM device_codes
+		WHERE device_code=$1
+		FOR UPDATE
+	`, deviceCode).Scan(
+		&ownerID,
+		&status,
+		&userID,
+		&authMethods,
+		&authTime,
+		&sid,
+		&pollInterval,
+		&lastPolledAt,
+		&expiresAt,
+	)
+	if err != nil || ownerID != clientID {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	if time.Now().After(expiresAt) {
+		tokenError(w, "expired_token")
+		return
+	}
+
+	// Clients polling faster than the interval are told to back off,
+	// and the interval they must keep grows each time.
+	if lastPolledAt.Valid && time.Since(lastPolledAt.Time) < time.Duration(pollInterval)*time.Second {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE device_codes SET poll_interval=poll_interval+5, last_polled_at=now() WHERE device_code=$1`,
+			deviceCode,
+		)
+		if err != nil || tx.Commit() != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		tokenError(w, "slow_down")
+		return
+	}
+
+	switch status {
+	case "pending":
+		_, err = tx.ExecContext(ctx,
+			`UPDATE device_codes SET last_polled_at=now() WHERE device_code=$1`,
+			deviceCode,
+		)
+		if err != nil || tx.Commit() != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		tokenError(w, "authorization_pending")
+		return
+
+	case "denied":
+		_, err = tx.ExecContext(ctx, `DELETE FROM device_codes WHERE device_code=$1`, deviceCode)
+		if err != nil || tx.Commit() != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		tokenError(w, "access_denied")
+		return
+	}
+
+	// Approved: the device code is single use.
+	_, err = tx.ExecContext(ctx, `DELETE FROM device_codes WHERE device_code=$1`, deviceCode)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	uid := int(userID.Int64)
+
+	opts, err := h.resourceOptions(r, nil)
+	if errors.Is(err, errInvalidTarget) {
+		tokenError(w, "invalid_target")
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	opts = append(opts,
+		jwtutil.WithCertThumbprint(client.CertThumbprint),
+		jwtutil.WithDPoPKey(jkt),
+	)
+	accessToken, err := h.Signer.MintAccessToken(ctx, uid, clientID, opts...)
+	if err != nil {
+		http.Error(w, "token signing failed", http.StatusInternalServerError)
+		return
+	}
+
+	idToken, err := h.Signer.MintIDToken(ctx, uid, clientID,
+		authTime.Time,
+		strings.Fields(authMethods.String),
+		sid.String,
+	)
+	if err != nil {
+		http.Error(w, "id token signing failed", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO session_clients (sid, client_id)
+		SELECT sid, $2 FROM sessions WHERE sid=$1
+		ON CONFLICT DO NOTHING
+	`, sid.String, clientID)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	rawRT, hashRT := generateRefreshToken()
+	rtID := uuid.New()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO refresh_tokens
+		(id, user_id, client_id, token_hash, expires_at, sid, dpop_jkt, cert_thumbprint)
+		VALUES ($1,$2,$3,$4, now() + interval '30 days', $5, $6, $7)
+	`,
+		rtID,
+		uid,
+		clientID,
+		hashRT,
+		sid.String,
+		refreshBinding(client, jkt),
+		refreshCertBinding(client),
+	)
+	if err != nil {
+		http.Error(w, "failed to store refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Record(r, audit.Event{
+		Type:     audit.TokenMinted,
+		UserID:   audit.User(uid),
+		ClientID: clientID,
+		Details: map[string]interface{}{
+			"grant_type":       deviceGrantType,
+			"refresh_token_id": rtID.String(),
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  accessToken,
+		"id_token":      idToken,
+		"refresh_token": rawRT,
+		"token_type":    tokenType(jkt),
 		"expires_in":    900,
 	})
 }
@@ -256,16 +605,52 @@ func (h *TokenHandler) Token(w http.ResponseWriter, r *http.Request) {
 
 	grantType := r.FormValue("grant_type")
 
+	ctx, span := tracing.Tracer.Start(r.Context(), "oauth.Token",
+		trace.WithAttributes(attribute.String("oauth.grant_type", grantType)),
+	)
+	defer span.End()
+	r = r.WithContext(ctx)
 
+	client, err := h.Clients.Authenticate(r)
+	if err != nil {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	var jkt string
+	if dpop.Present(r) {
+		jkt, err = h.DPoP.Verify(ctx, r, "")
+		if errors.Is(err, dpop.ErrInvalidProof) {
+			w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+			tokenError(w, "invalid_dpop_proof")
+			return
+		}
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	switch grantType {
 
 	case "authorization_code":
-	    h.handleAuthorizationCode(w,r)
+	    h.handleAuthorizationCode(w,r, client, jkt)
 		return
 
 	case "refresh_token":
-		h.handleRefreshToken(w, r)
+		h.handleRefreshToken(w, r, client, jkt)
+		return
+
+	case deviceGrantType:
+		h.handleDeviceCode(w, r, client, jkt)
+		return
+
+	case tokenExchangeGrantType:
+		h.handleTokenExchange(w, r, client, jkt)
+		return
+
+	case jwtBearerGrantType:
+		h.handleJWTBearer(w, r, client, jkt)
 		return
 
 	default:
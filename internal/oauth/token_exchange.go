@@ -0,0 +1,227 @@
+package oauth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/audit"
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenClientID returns the client an access token was issued to.
+func tokenClientID(claims jwt.MapClaims) string {
+	id, _ := claims["client_id"].(string)
+	return id
+}
+
+// possessed reports whether r proves possession of the DPoP key (whose
+// thumbprint is jkt) or client certificate claims are bound to, if any.
+func possessed(claims jwt.MapClaims, r *http.Request, jkt string) bool {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	if want, _ := cnf["jkt"].(string); want != "" && want != jkt {
+		return false
+	}
+	return middleware.CertBindingMatches(claims, r)
+}
+
+// handleTokenExchange implements RFC 8693: it trades a user's access
+// token for one addressed to another audience, with at most the scopes
+// the client's policy for that audience allows. With an actor_token the
+// new token records the actor in an act claim (delegation); without one
+// it simply stands in for the user (impersonation).
+func (h *TokenHandler) handleTokenExchange(w http.ResponseWriter, r *http.Request, client *Client, jkt string) {
+	subjectToken := r.FormValue("subject_token")
+	actorToken := r.FormValue("actor_token")
+	actorTokenType := r.FormValue("actor_token_type")
+	audience := r.FormValue("audience")
+	resources := r.PostForm["resource"]
+	if audience == "" && len(resources) == 1 {
+		// Clients using resource indicators name the target as a
+		// resource instead.
+		audience = resources[0]
+	}
+	if len(resources) > 1 || (len(resources) == 1 && resources[0] != audience) {
+		tokenError(w, "invalid_target")
+		return
+	}
+
+	if subjectToken == "" || r.FormValue("subject_token_type") != accessTokenType || audience == "" {
+		tokenError(w, "invalid_request")
+		return
+	}
+	if t := r.FormValue("requested_token_type"); t != "" && t != accessTokenType {
+		tokenError(w, "invalid_request")
+		return
+	}
+	if (actorToken == "") != (actorTokenType == "") || (actorTokenType != "" && actorTokenType != accessTokenType) {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	ctx := r.Context()
+
+	var (
+		policyScopes string
+		requireActor bool
+	)
+	err := h.DB.QueryRowContext(ctx,
+		`SELECT scopes, require_actor FROM token_exchange_policies
+		 WHERE client_id=$1 AND audience=$2`,
+		client.ID, audience,
+	).Scan(&policyScopes, &requireActor)
+	if err == sql.ErrNoRows {
+		tokenError(w, "invalid_target")
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	// A resource must be a registered API. Whenever the audience is one,
+	// however it was named, the token only keeps the scopes it accepts.
+	accepted, err := lookupResource(ctx, h.DB, audience)
+	if errors.Is(err, errInvalidTarget) && len(resources) == 1 {
+		tokenError(w, "invalid_target")
+		return
+	}
+	if err != nil && !errors.Is(err, errInvalidTarget) {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		var kept []string
+		for _, s := range strings.Fields(policyScopes) {
+			if slices.Contains(accepted, s) {
+				kept = append(kept, s)
+			}
+		}
+		policyScopes = strings.Join(kept, " ")
+	}
+
+	km := h.Signer.KeyManager
+	issuer := h.Signer.Issuer
+
+	// A sender-constrained token is only exchanged by its holder.
+	subject, err := middleware.ParseAccessToken(ctx, h.DB, km, issuer, subjectToken)
+	if err != nil || !possessed(subject, r, jkt) {
+		tokenError(w, "invalid_request")
+		return
+	}
+	sub, ok := subject["sub"].(float64)
+	if !ok {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	// A subject token that was itself delegated keeps its chain of
+	// actors; a new actor goes on the outside.
+	act, _ := subject["act"].(map[string]interface{})
+
+	var actorSub interface{}
+	if actorToken != "" {
+		actor, err := middleware.ParseAccessToken(ctx, h.DB, km, issuer, actorToken)
+		if err != nil || tokenClientID(actor) != client.ID || !possessed(actor, r, jkt) {
+			tokenError(w, "invalid_request")
+			return
+		}
+		actorSub = actor["sub"]
+
+		delegated := map[string]interface{}{"sub": actorSub}
+		if act != nil {
+			delegated["act"] = act
+		}
+		act = delegated
+	} else if requireActor {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	granted, _ := subject["scope"].(string)
+	scopes, ok := narrowScopes(granted, policyScopes, r.FormValue("scope"))
+	if !ok {
+		tokenError(w, "invalid_scope")
+		return
+	}
+
+	accessToken, err := h.Signer.MintAccessToken(ctx, int(sub), client.ID,
+		jwtutil.WithAudience(audience),
+		jwtutil.WithScopes(scopes),
+		jwtutil.WithActor(act),
+		jwtutil.WithCertThumbprint(client.CertThumbprint),
+		jwtutil.WithDPoPKey(jkt),
+	)
+	if err != nil {
+		http.Error(w, "token signing failed", http.StatusInternalServerError)
+		return
+	}
+
+	details := map[string]interface{}{
+		"grant_type":  tokenExchangeGrantType,
+		"audience":    audience,
+		"subject_jti": subject["jti"],
+	}
+	if actorSub != nil {
+		details["actor_sub"] = actorSub
+	}
+	h.Audit.Record(r, audit.Event{
+		Type:     audit.TokenMinted,
+		UserID:   audit.User(int(sub)),
+		ClientID: client.ID,
+		Details:  details,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":      accessToken,
+		"issued_token_type": accessTokenType,
+		"token_type":        tokenType(jkt),
+		"expires_in":        900,
+		"scope":             strings.Join(scopes, " "),
+	})
+}
+
+// narrowScopes returns the scopes of granted that policy allows, or only
+// the requested ones if any were asked for. It reports false when a
+// requested scope is not available.
+func narrowScopes(granted, policy, requested string) ([]string, bool) {
+	allowed := make(map[string]bool)
+	for _, s := range strings.Fields(policy) {
+		allowed[s] = true
+	}
+
+	available := make(map[string]bool)
+	scopes := []string{}
+	for _, s := range strings.Fields(granted) {
+		if allowed[s] && !available[s] {
+			available[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+
+	if requested == "" {
+		return scopes, true
+	}
+
+	scopes = []string{}
+	for _, s := range strings.Fields(requested) {
+		if !available[s] {
+			return nil, false
+		}
+		scopes = append(scopes, s)
+	}
+	return scopes, true
+}
@@ -0,0 +1,31 @@
+package oauth
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNarrowScopes(t *testing.T) {
+	tests := []struct {
+		name      string
+		granted   string
+		policy    string
+		requested string
+		want      []string
+		ok        bool
+	}{
+		{"policy limits granted", "openid profile admin:audit", "profile email", "", []string{"profile"}, true},
+		{"empty policy", "openid profile", "", "", []string{}, true},
+		{"duplicates dropped", "profile profile", "profile", "", []string{"profile"}, true},
+		{"requested subset", "profile email", "profile email", "email", []string{"email"}, true},
+		{"requested outside policy", "profile admin:audit", "profile", "admin:audit", nil, false},
+		{"requested not granted", "profile", "profile email", "email", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := narrowScopes(tt.granted, tt.policy, tt.requested)
+		if ok != tt.ok || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: narrowScopes = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
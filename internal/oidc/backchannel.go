@@ -0,0 +1,130 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+)
+
+const (
+	backchannelBatchSize   = 50
+	backchannelMaxAttempts = 8
+)
+
+// BackchannelNotifier delivers queued back-channel logout tokens,
+// retrying failed deliveries with exponential backoff.
+type BackchannelNotifier struct {
+	DB     *sql.DB
+	Signer *jwtutil.Signer
+	Client *http.Client
+}
+
+type pendingDelivery struct {
+	id       uuid.UUID
+	clientID string
+	uri      string
+	userID   int
+	sid      string
+	attempts int
+}
+
+// DeliverPending sends every notification that is due. Rows are leased
+// for a minute while being delivered so concurrent instances don't send
+// the same notification twice.
+func (n *BackchannelNotifier) DeliverPending() error {
+	rows, err := n.DB.Query(`
+		UPDATE backchannel_logout_deliveries
+		SET next_attempt_at = now() + interval '1 minute'
+		WHERE id IN (
+			SELECT id FROM backchannel_logout_deliveries
+			WHERE next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, client_id, uri, user_id, sid, attempts
+	`, backchannelBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var due []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.id, &d.clientID, &d.uri, &d.userID, &d.sid, &d.attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if err := n.deliver(d); err != nil {
+			n.reschedule(d, err)
+			continue
+		}
+
+		if _, err := n.DB.Exec("DELETE FROM backchannel_logout_deliveries WHERE id=$1", d.id); err != nil {
+			log.Println("backchannel logout cleanup failed:", err)
+		}
+	}
+
+	return nil
+}
+
+func (n *BackchannelNotifier) deliver(d pendingDelivery) error {
+	// Minted per attempt so retries don't send an expired token.
+	token, err := n.Signer.MintLogoutToken(context.Background(), d.userID, d.clientID, d.sid)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.Client.Post(
+		d.uri,
+		"application/x-www-form-urlencoded",
+		strings.NewReader(url.Values{"logout_token": {token}}.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *BackchannelNotifier) reschedule(d pendingDelivery, cause error) {
+	attempts := d.attempts + 1
+
+	if attempts >= backchannelMaxAttempts {
+		log.Printf("backchannel logout to client %s abandoned after %d attempts: %v", d.clientID, attempts, cause)
+		n.DB.Exec("DELETE FROM backchannel_logout_deliveries WHERE id=$1", d.id)
+		return
+	}
+
+	backoff := time.Duration(1<<attempts) * 15 * time.Second
+
+	_, err := n.DB.Exec(`
+		UPDATE backchannel_logout_deliveries
+		SET attempts=$1, next_attempt_at=$2, last_error=$3
+		WHERE id=$4
+	`, attempts, time.Now().Add(backoff), cause.Error(), d.id)
+	if err != nil {
+		log.Println("backchannel logout reschedule failed:", err)
+	}
+}
@@ -3,9 +3,13 @@ package oidc
 import (
 	"encoding/json"
 	"net/http"
+
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
 )
 
-func DiscoveryHandler(issuer string) http.HandlerFunc {
+// DiscoveryHandler serves the provider metadata. requirePAR advertises
+// that every client must use pushed authorization requests.
+func DiscoveryHandler(issuer string, requirePAR bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		doc := map[string]interface{}{
@@ -14,6 +18,14 @@ func DiscoveryHandler(issuer string) http.HandlerFunc {
 			"authorization_endpoint": issuer + "/authorize",
 			"token_endpoint":         issuer + "/token",
 			"jwks_uri":               issuer + "/jwks",
+			"end_session_endpoint":   issuer + "/end_session",
+			"userinfo_endpoint":      issuer + "/userinfo",
+			"introspection_endpoint": issuer + "/introspect",
+
+			"device_authorization_endpoint": issuer + "/device_authorization",
+
+			"pushed_authorization_request_endpoint": issuer + "/par",
+			"require_pushed_authorization_requests": requirePAR,
 
 			"response_types_supported": []string{
 				"code",
@@ -22,6 +34,9 @@ func DiscoveryHandler(issuer string) http.HandlerFunc {
 			"grant_types_supported": []string{
 				"authorization_code",
 				"refresh_token",
+				"urn:ietf:params:oauth:grant-type:device_code",
+				"urn:ietf:params:oauth:grant-type:token-exchange",
+				"urn:ietf:params:oauth:grant-type:jwt-bearer",
 			},
 
 			"subject_types_supported": []string{
@@ -37,15 +52,38 @@ func DiscoveryHandler(issuer string) http.HandlerFunc {
 				"read:data",
 				"write:data",
 				"admin:users",
+				"admin:audit",
 			},
 
 			"token_endpoint_auth_methods_supported": []string{
 				"none",
+				"tls_client_auth",
+				"self_signed_tls_client_auth",
+				"private_key_jwt",
+				"client_secret_jwt",
 			},
 
+			"token_endpoint_auth_signing_alg_values_supported": append(
+				[]string{"HS256", "HS384", "HS512"}, jwtutil.SigningMethods...,
+			),
+
+			"tls_client_certificate_bound_access_tokens": true,
+
+			"dpop_signing_alg_values_supported": jwtutil.SigningMethods,
+
+			"request_parameter_supported":                 true,
+			"request_uri_parameter_supported":             true,
+			"require_request_uri_registration":            true,
+			"request_object_signing_alg_values_supported": jwtutil.SigningMethods,
+
 			"code_challenge_methods_supported": []string{
 				"S256",
 			},
+
+			"backchannel_logout_supported":          true,
+			"backchannel_logout_session_supported":  true,
+			"frontchannel_logout_supported":         true,
+			"frontchannel_logout_session_supported": true,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
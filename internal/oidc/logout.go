@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/auth"
+	jwtutil "github.com/SAMurai-16/sentinel-idp/internal/jwt"
+	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
+)
+
+// LogoutHandler implements OIDC RP-Initiated Logout 1.0 at the
+// end_session_endpoint.
+type LogoutHandler struct {
+	DB         *sql.DB
+	Issuer     string
+	KeyManager *jwtutil.KeyManager
+}
+
+type logoutRequest struct {
+	IDTokenHint           string
+	ClientID              string
+	PostLogoutRedirectURI string
+	State                 string
+}
+
+// validate checks id_token_hint and post_logout_redirect_uri. The
+// redirect is only honoured when it is registered for the client the
+// request identifies.
+func (h *LogoutHandler) validate(req *logoutRequest) error {
+	if req.IDTokenHint != "" {
+		// The hint may be an expired id_token, so only the signature
+		// and issuer are checked, and that it has the shape of an
+		// id_token: addressed to a client, with auth_time and without
+		// the scope of an access token.
+		claims, err := h.KeyManager.ParseIDToken(
+			req.IDTokenHint,
+			jwt.WithoutClaimsValidation(),
+		)
+		if err != nil {
+			return err
+		}
+
+		if iss, _ := claims["iss"].(string); iss != h.Issuer {
+			return jwt.ErrTokenInvalidIssuer
+		}
+
+		if _, ok := claims["auth_time"].(float64); !ok {
+			return errInvalidIDTokenHint
+		}
+		if _, ok := claims["scope"]; ok {
+			return errInvalidIDTokenHint
+		}
+
+		aud, _ := claims["aud"].(string)
+		if req.ClientID != "" && req.ClientID != aud {
+			return jwt.ErrTokenInvalidAudience
+		}
+
+		var exists bool
+		err = h.DB.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM oauth_clients WHERE client_id=$1)",
+			aud,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return jwt.ErrTokenInvalidAudience
+		}
+		req.ClientID = aud
+	}
+
+	if req.PostLogoutRedirectURI == "" {
+		return nil
+	}
+
+	if req.ClientID == "" {
+		return errInvalidLogoutRedirect
+	}
+
+	var registered sql.NullString
+	err := h.DB.QueryRow(
+		"SELECT post_logout_redirect_uri FROM oauth_clients WHERE client_id=$1",
+		req.ClientID,
+	).Scan(&registered)
+	if err != nil || !registered.Valid || registered.String != req.PostLogoutRedirectURI {
+		return errInvalidLogoutRedirect
+	}
+
+	return nil
+}
+
+var (
+	errInvalidIDTokenHint    = errors.New("id_token_hint is not an id_token")
+	errInvalidLogoutRedirect = errors.New("post_logout_redirect_uri not registered for client")
+)
+
+// EndSession shows a confirmation page on GET and performs the logout
+// on POST (CSRF protected).
+func (h *LogoutHandler) EndSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &logoutRequest{
+		IDTokenHint:           r.FormValue("id_token_hint"),
+		ClientID:              r.FormValue("client_id"),
+		PostLogoutRedirectURI: r.FormValue("post_logout_redirect_uri"),
+		State:                 r.FormValue("state"),
+	}
+
+	if err := h.validate(req); err != nil {
+		http.Error(w, "invalid logout request", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		tmpl, err := template.ParseFiles("web/templates/logout_confirm.html")
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		tmpl.Execute(w, map[string]string{
+			"CSRFToken":             middleware.IssueCSRFToken(w),
+			"IDTokenHint":           req.IDTokenHint,
+			"ClientID":              req.ClientID,
+			"PostLogoutRedirectURI": req.PostLogoutRedirectURI,
+			"State":                 req.State,
+		})
+		return
+	}
+
+	var ended *EndedSession
+	if cookie, err := r.Cookie("sentinel_session"); err == nil {
+		ended, err = EndSession(h.DB, cookie.Value)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	auth.ClearSessionCookie(w)
+
+	var redirect string
+	if req.PostLogoutRedirectURI != "" {
+		u, err := url.Parse(req.PostLogoutRedirectURI)
+		if err != nil {
+			http.Error(w, "invalid logout request", http.StatusBadRequest)
+			return
+		}
+		if req.State != "" {
+			q := u.Query()
+			q.Set("state", req.State)
+			u.RawQuery = q.Encode()
+		}
+		redirect = u.String()
+	}
+
+	var frames []string
+	if ended != nil {
+		frames = h.frontchannelFrames(ended)
+	}
+
+	// Without front-channel clients there is nothing to render.
+	if redirect != "" && len(frames) == 0 {
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("web/templates/logged_out.html")
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, map[string]interface{}{
+		"Frames":   frames,
+		"Redirect": redirect,
+	})
+}
+
+// frontchannelFrames builds the iframe URLs for OIDC Front-Channel
+// Logout, passing iss and sid to each client.
+func (h *LogoutHandler) frontchannelFrames(ended *EndedSession) []string {
+	var frames []string
+	for _, uri := range ended.FrontchannelLogoutURIs {
+		u, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+		q := u.Query()
+		q.Set("iss", h.Issuer)
+		q.Set("sid", ended.SID)
+		u.RawQuery = q.Encode()
+		frames = append(frames, u.String())
+	}
+	return frames
+}
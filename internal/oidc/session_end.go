@@ -0,0 +1,145 @@
+package oidc
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// EndedSession describes a terminated session and the front-channel
+// logout URIs of the clients that were issued tokens in it.
+type EndedSession struct {
+	SID                    string
+	FrontchannelLogoutURIs []string
+}
+
+// EndSession deletes the session identified by its cookie value,
+// revokes the refresh tokens issued under it and queues back-channel
+// logout notifications for every client that was issued tokens in it.
+// It returns nil if the session does not exist.
+func EndSession(db *sql.DB, sessionID string) (*EndedSession, error) {
+	return endSession(db, "SELECT user_id, sid FROM sessions WHERE id=$1 FOR UPDATE", sessionID)
+}
+
+// EndSessionBySID is EndSession for callers that only know the public
+// session identifier.
+func EndSessionBySID(db *sql.DB, sid string) (*EndedSession, error) {
+	return endSession(db, "SELECT user_id, sid FROM sessions WHERE sid=$1 FOR UPDATE", sid)
+}
+
+// EndUserSessions ends every session of userID.
+func EndUserSessions(db *sql.DB, userID int) (int, error) {
+	rows, err := db.Query("SELECT sid FROM sessions WHERE user_id=$1", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var sids []string
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		sids = append(sids, sid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	ended := 0
+	for _, sid := range sids {
+		s, err := EndSessionBySID(db, sid)
+		if err != nil {
+			return ended, err
+		}
+		if s != nil {
+			ended++
+		}
+	}
+
+	return ended, nil
+}
+
+func endSession(db *sql.DB, lookup string, key string) (*EndedSession, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var sid string
+	err = tx.QueryRow(lookup, key).Scan(&userID, &sid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT c.client_id, c.backchannel_logout_uri, c.frontchannel_logout_uri
+		FROM session_clients sc
+		JOIN oauth_clients c ON c.client_id = sc.client_id
+		WHERE sc.sid = $1
+	`, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	ended := &EndedSession{SID: sid}
+
+	type backchannel struct{ clientID, uri string }
+	var notify []backchannel
+
+	for rows.Next() {
+		var clientID string
+		var backURI, frontURI sql.NullString
+		if err := rows.Scan(&clientID, &backURI, &frontURI); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if backURI.Valid && backURI.String != "" {
+			notify = append(notify, backchannel{clientID, backURI.String})
+		}
+		if frontURI.Valid && frontURI.String != "" {
+			ended.FrontchannelLogoutURIs = append(ended.FrontchannelLogoutURIs, frontURI.String)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, n := range notify {
+		_, err = tx.Exec(
+			`INSERT INTO backchannel_logout_deliveries (id, client_id, uri, user_id, sid)
+			 VALUES ($1,$2,$3,$4,$5)`,
+			uuid.New(), n.clientID, n.uri, userID, sid,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE refresh_tokens SET revoked=true WHERE sid=$1 AND revoked=false",
+		sid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM sessions WHERE sid=$1", sid); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ended, nil
+}
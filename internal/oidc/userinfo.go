@@ -0,0 +1,56 @@
+package oidc
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/middleware"
+)
+
+// UserinfoHandler serves the OIDC UserInfo endpoint. It must be wrapped
+// in middleware.RequireAccessToken, which also enforces certificate
+// binding.
+func UserinfoHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := middleware.ClaimsFromContext(r.Context())
+
+		sub, ok := claims["sub"].(float64)
+		if !ok {
+			http.Error(w, "invalid subject", http.StatusUnauthorized)
+			return
+		}
+
+		var (
+			username      string
+			email         sql.NullString
+			emailVerified bool
+		)
+		err := db.QueryRowContext(r.Context(),
+			"SELECT username, email, email_verified FROM users WHERE id=$1",
+			int(sub),
+		).Scan(&username, &email, &emailVerified)
+		if err == sql.ErrNoRows {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "unknown user", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+
+		info := map[string]interface{}{
+			"sub":                int(sub),
+			"preferred_username": username,
+		}
+		if email.Valid {
+			info["email"] = email.String
+			info["email_verified"] = emailVerified
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -2,9 +2,14 @@ package storage
 
 import (
 	"database/sql"
+
 	_ "github.com/lib/pq"
+
+	"github.com/SAMurai-16/sentinel-idp/internal/tracing"
 )
 
+// Open connects to Postgres. Queries made with a traced request context
+// are recorded as spans.
 func Open(dsn string) (*sql.DB, error) {
-	return sql.Open("postgres", dsn)
+	return tracing.OpenDB("postgres", dsn)
 }
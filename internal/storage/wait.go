@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// WaitForDB pings db until it answers, retrying with exponential
+// backoff up to attempts times. sql.Open does not connect, so this is
+// the first point a bad DATABASE_URL or unreachable server shows up.
+func WaitForDB(ctx context.Context, db *sql.DB, attempts int) error {
+	delay := time.Second
+
+	var err error
+	for i := 1; i <= attempts; i++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = db.PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if i == attempts {
+			break
+		}
+
+		log.Printf("database not reachable (attempt %d/%d): %v; retrying in %s", i, attempts, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if delay < 30*time.Second {
+			delay *= 2
+		}
+	}
+
+	return fmt.Errorf("database not reachable after %d attempts: %w", attempts, err)
+}
@@ -0,0 +1,38 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// Server returns a TLS configuration serving certificates from reloader.
+// With requestClientCert, clients may present a certificate. It is not
+// verified during the handshake, since self-signed certificates are
+// allowed for some clients; handlers that rely on one verify it.
+func Server(reloader *CertReloader, requestClientCert bool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if requestClientCert {
+		cfg.ClientAuth = tls.RequestClientCert
+	}
+	return cfg
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, errors.New("no certificates found in " + file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,19 @@
+CREATE TABLE webauthn_credentials (
+    id BYTEA PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+
+CREATE TABLE webauthn_sessions (
+    id UUID PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    data JSONB NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE sessions ADD COLUMN auth_methods TEXT NOT NULL DEFAULT 'pwd';
+ALTER TABLE authorization_codes ADD COLUMN auth_methods TEXT NOT NULL DEFAULT 'pwd';
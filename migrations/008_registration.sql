@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN email TEXT UNIQUE;
+
+-- Existing accounts were provisioned by hand and are treated as verified.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT false;
+
+CREATE TABLE email_verification_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
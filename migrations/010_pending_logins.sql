@@ -0,0 +1,5 @@
+CREATE TABLE pending_logins (
+    id UUID PRIMARY KEY,
+    return_to TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
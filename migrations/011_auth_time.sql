@@ -0,0 +1,2 @@
+ALTER TABLE sessions ADD COLUMN authenticated_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE authorization_codes ADD COLUMN auth_time TIMESTAMP NOT NULL DEFAULT now();
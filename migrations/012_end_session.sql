@@ -0,0 +1 @@
+ALTER TABLE oauth_clients ADD COLUMN post_logout_redirect_uri TEXT;
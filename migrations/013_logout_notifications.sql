@@ -0,0 +1,30 @@
+ALTER TABLE sessions ADD COLUMN sid TEXT;
+UPDATE sessions SET sid = gen_random_uuid()::text;
+ALTER TABLE sessions ALTER COLUMN sid SET NOT NULL;
+ALTER TABLE sessions ADD CONSTRAINT sessions_sid_key UNIQUE (sid);
+
+ALTER TABLE authorization_codes ADD COLUMN sid TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE oauth_clients ADD COLUMN backchannel_logout_uri TEXT;
+ALTER TABLE oauth_clients ADD COLUMN frontchannel_logout_uri TEXT;
+
+-- Clients that were issued tokens within a session.
+CREATE TABLE session_clients (
+    sid TEXT NOT NULL REFERENCES sessions(sid) ON DELETE CASCADE,
+    client_id TEXT NOT NULL,
+    PRIMARY KEY (sid, client_id)
+);
+
+CREATE TABLE backchannel_logout_deliveries (
+    id UUID PRIMARY KEY,
+    client_id TEXT NOT NULL,
+    uri TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    sid TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_backchannel_logout_due ON backchannel_logout_deliveries(next_attempt_at);
@@ -0,0 +1,10 @@
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP NOT NULL DEFAULT now();
+
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+
+ALTER TABLE refresh_tokens ADD COLUMN sid TEXT;
+
+CREATE INDEX idx_refresh_tokens_sid ON refresh_tokens(sid);
@@ -0,0 +1,7 @@
+ALTER TABLE sessions ADD COLUMN absolute_expires_at TIMESTAMP;
+UPDATE sessions SET absolute_expires_at = expires_at;
+ALTER TABLE sessions ALTER COLUMN absolute_expires_at SET NOT NULL;
+
+CREATE INDEX idx_sessions_expires ON sessions(expires_at);
+CREATE INDEX idx_authorization_codes_expires ON authorization_codes(expires_at);
+CREATE INDEX idx_refresh_tokens_expires ON refresh_tokens(expires_at);
@@ -0,0 +1,14 @@
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP NOT NULL DEFAULT now(),
+    type TEXT NOT NULL,
+    user_id INTEGER,
+    client_id TEXT,
+    ip TEXT,
+    user_agent TEXT,
+    details JSONB
+);
+
+CREATE INDEX idx_audit_events_time ON audit_events(occurred_at);
+CREATE INDEX idx_audit_events_type ON audit_events(type, occurred_at);
+CREATE INDEX idx_audit_events_user ON audit_events(user_id, occurred_at);
@@ -0,0 +1,13 @@
+-- RFC 8705 mutual-TLS client authentication.
+ALTER TABLE oauth_clients
+    ADD COLUMN token_endpoint_auth_method TEXT NOT NULL DEFAULT 'none'
+        CHECK (token_endpoint_auth_method IN ('none', 'tls_client_auth', 'self_signed_tls_client_auth'));
+
+-- tls_client_auth: the certificate must chain to TLS_CLIENT_CA_FILE and
+-- carry this subject DN or DNS SAN.
+ALTER TABLE oauth_clients ADD COLUMN tls_client_auth_subject_dn TEXT;
+ALTER TABLE oauth_clients ADD COLUMN tls_client_auth_san_dns TEXT;
+
+-- self_signed_tls_client_auth: JWK Set whose keys carry the client's
+-- certificates in x5c.
+ALTER TABLE oauth_clients ADD COLUMN jwks JSONB;
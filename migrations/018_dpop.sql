@@ -0,0 +1,13 @@
+-- DPoP proof jtis seen recently, to reject replayed proofs.
+CREATE TABLE dpop_proofs (
+    jkt TEXT NOT NULL,
+    jti TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (jkt, jti)
+);
+
+CREATE INDEX idx_dpop_proofs_expires_at ON dpop_proofs(expires_at);
+
+-- Refresh tokens of public clients are bound to the DPoP key they were
+-- issued to.
+ALTER TABLE refresh_tokens ADD COLUMN dpop_jkt TEXT;
@@ -0,0 +1,11 @@
+-- Pushed authorization requests (RFC 9126).
+CREATE TABLE pushed_authorization_requests (
+    request_uri TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL,
+    params TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_pushed_authorization_requests_expires_at ON pushed_authorization_requests(expires_at);
+
+ALTER TABLE oauth_clients ADD COLUMN require_pushed_authorization_requests BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+-- Request object URLs (RFC 9101) a client may pass as request_uri,
+-- space-separated. Request objects are verified with oauth_clients.jwks.
+ALTER TABLE oauth_clients ADD COLUMN request_uris TEXT;
@@ -0,0 +1,22 @@
+-- private_key_jwt and client_secret_jwt client authentication (RFC 7523).
+ALTER TABLE oauth_clients DROP CONSTRAINT oauth_clients_token_endpoint_auth_method_check;
+ALTER TABLE oauth_clients ADD CONSTRAINT oauth_clients_token_endpoint_auth_method_check
+    CHECK (token_endpoint_auth_method IN (
+        'none', 'tls_client_auth', 'self_signed_tls_client_auth', 'private_key_jwt', 'client_secret_jwt'
+    ));
+
+-- Keys for private_key_jwt may be published instead of stored in jwks.
+ALTER TABLE oauth_clients ADD COLUMN jwks_uri TEXT;
+
+-- client_secret_jwt signs with HMAC, so the secret is kept as issued.
+ALTER TABLE oauth_clients ADD COLUMN client_secret TEXT;
+
+-- Assertion jtis seen before their expiry, to reject replays.
+CREATE TABLE client_assertion_jtis (
+    client_id TEXT NOT NULL,
+    jti TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
+
+CREATE INDEX idx_client_assertion_jtis_expires_at ON client_assertion_jtis(expires_at);
@@ -0,0 +1,17 @@
+-- Device authorization grant (RFC 8628).
+CREATE TABLE device_codes (
+    device_code TEXT PRIMARY KEY,
+    user_code TEXT UNIQUE NOT NULL,
+    client_id TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'denied')),
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    auth_methods TEXT,
+    auth_time TIMESTAMP,
+    sid TEXT,
+    poll_interval INTEGER NOT NULL,
+    last_polled_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_device_codes_expires_at ON device_codes(expires_at);
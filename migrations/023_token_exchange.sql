@@ -0,0 +1,10 @@
+-- Token exchange (RFC 8693): which audiences each client may exchange
+-- tokens for, and the most scope the exchanged tokens may carry.
+CREATE TABLE token_exchange_policies (
+    client_id TEXT NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    audience TEXT NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    -- Only allow delegation (an actor_token), never impersonation.
+    require_actor BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (client_id, audience)
+);
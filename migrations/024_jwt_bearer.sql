@@ -0,0 +1,29 @@
+-- JWT bearer grant (RFC 7523): issuers whose JWTs are accepted at /token.
+CREATE TABLE trusted_issuers (
+    issuer TEXT PRIMARY KEY,
+    jwks JSONB,
+    jwks_uri TEXT,
+    -- How an assertion's sub is matched to a local user: through
+    -- trusted_issuer_subjects, or against users.username or (verified)
+    -- users.email.
+    subject_mapping TEXT NOT NULL DEFAULT 'explicit'
+        CHECK (subject_mapping IN ('explicit', 'username', 'email')),
+    CHECK (jwks IS NOT NULL OR jwks_uri IS NOT NULL)
+);
+
+CREATE TABLE trusted_issuer_subjects (
+    issuer TEXT NOT NULL REFERENCES trusted_issuers(issuer) ON DELETE CASCADE,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (issuer, subject)
+);
+
+-- Assertion jtis seen before their expiry, to reject replays.
+CREATE TABLE jwt_bearer_jtis (
+    issuer TEXT NOT NULL,
+    jti TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, jti)
+);
+
+CREATE INDEX idx_jwt_bearer_jtis_expires_at ON jwt_bearer_jtis(expires_at);
@@ -0,0 +1,11 @@
+-- Resource indicators (RFC 8707): APIs access tokens can be addressed
+-- to, and the scopes tokens for each may carry.
+CREATE TABLE api_resources (
+    resource TEXT PRIMARY KEY,
+    scopes TEXT NOT NULL DEFAULT ''
+);
+
+-- Resources requested at /authorize; tokens for the grant may only be
+-- addressed to these.
+ALTER TABLE authorization_codes ADD COLUMN resources TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN resources TEXT NOT NULL DEFAULT '';
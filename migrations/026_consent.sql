@@ -0,0 +1,10 @@
+-- Clients that need the user's explicit consent before /authorize
+-- issues a code, and the consents users have given.
+ALTER TABLE oauth_clients ADD COLUMN require_consent BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE user_consents (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id TEXT NOT NULL,
+    granted_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, client_id)
+);
@@ -0,0 +1,3 @@
+-- Refresh tokens of public clients that presented a client certificate
+-- are bound to it (RFC 8705 section 4).
+ALTER TABLE refresh_tokens ADD COLUMN cert_thumbprint TEXT;
@@ -0,0 +1,9 @@
+-- Request object jtis seen before their expiry, to reject replays.
+CREATE TABLE request_object_jtis (
+    client_id TEXT NOT NULL,
+    jti TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
+
+CREATE INDEX idx_request_object_jtis_expires_at ON request_object_jtis(expires_at);
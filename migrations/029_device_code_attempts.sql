@@ -0,0 +1,8 @@
+-- Wrong user codes entered on the device verification page, to limit
+-- guessing (RFC 8628 section 5.1).
+CREATE TABLE device_code_attempts (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    attempted_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_device_code_attempts_user_id ON device_code_attempts(user_id, attempted_at);
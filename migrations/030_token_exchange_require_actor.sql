@@ -0,0 +1,3 @@
+-- New token exchange policies only allow delegation unless they opt in
+-- to impersonation.
+ALTER TABLE token_exchange_policies ALTER COLUMN require_actor SET DEFAULT true;
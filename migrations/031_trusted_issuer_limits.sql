@@ -0,0 +1,4 @@
+-- Which clients may present a trusted issuer's JWTs (space-separated
+-- client_ids), and the most scope the resulting tokens may carry.
+ALTER TABLE trusted_issuers ADD COLUMN allowed_clients TEXT NOT NULL DEFAULT '';
+ALTER TABLE trusted_issuers ADD COLUMN scopes TEXT NOT NULL DEFAULT '';